@@ -0,0 +1,230 @@
+package yafsm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MarkTerminal flags st as an intended end state, Analyze doesn't report it
+// as a dead end nor the cycles containing it as traps.
+func (st *State) MarkTerminal() {
+	st.terminal = true
+}
+
+func (st *State) Terminal() bool {
+	return st.terminal
+}
+
+// Report is the result of Analyze, state names are sorted.
+type Report struct {
+	// Unreachable states can't be reached from the initial state of their
+	// region. A parent state counts as reached if any descendant is.
+	Unreachable []string
+	// DeadEnds are reachable non-terminal states without any event.
+	DeadEnds []string
+	// Dangling events have a From or To that is no longer the registered
+	// state of that name, e.g. it was deleted and added again.
+	Dangling []*Event
+	// Traps are cycles, including self-transitions, that no event leaves
+	// and that contain no terminal state.
+	Traps [][]string
+}
+
+func (r *Report) OK() bool {
+	return len(r.Unreachable) == 0 && len(r.DeadEnds) == 0 &&
+		len(r.Dangling) == 0 && len(r.Traps) == 0
+}
+
+// Validate returns nil if Analyze finds nothing, otherwise every finding
+// joined in one error.
+func (fsm *FSM) Validate() error {
+	report := fsm.Analyze()
+	errs := []error{}
+	for _, state := range report.Unreachable {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrStateUnreachable, state))
+	}
+	for _, state := range report.DeadEnds {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrStateDeadEnd, state))
+	}
+	for _, et := range report.Dangling {
+		from := AnyState
+		if et.From != nil {
+			from = et.From.State
+		}
+		errs = append(errs, fmt.Errorf("%w: %s from %s to %s", ErrEventDangling, et.Event, from, et.To.State))
+	}
+	for _, trap := range report.Traps {
+		errs = append(errs, fmt.Errorf("%w: %v", ErrStateTrap, trap))
+	}
+	return errors.Join(errs...)
+}
+
+// Analyze inspects the state graph as built so far.
+func (fsm *FSM) Analyze() *Report {
+	fsm.mutex.RLock()
+	defer fsm.mutex.RUnlock()
+
+	report := &Report{}
+	// outgoing transitions of every state, resolved the way emission does
+	edges := make(map[*State][]*State, len(fsm.states))
+	for _, st := range fsm.states {
+		for event, etList := range fsm.events {
+			if et := match(etList, st); et != nil {
+				edges[st] = append(edges[st], target(st, et))
+				continue
+			}
+			if et, ok := fsm.anyEvents[event][st.region]; ok {
+				edges[st] = append(edges[st], et.To)
+			}
+		}
+		for event, ets := range fsm.anyEvents {
+			if _, ok := fsm.events[event]; ok {
+				continue
+			}
+			if et, ok := ets[st.region]; ok {
+				edges[st] = append(edges[st], et.To)
+			}
+		}
+	}
+
+	// reachability from the initial states
+	reached := make(map[*State]bool, len(fsm.states))
+	queue := []*State{}
+	for _, region := range fsm.regionNames() {
+		if st, ok := fsm.states[fsm.initials[region]]; ok {
+			reached[st] = true
+			queue = append(queue, st)
+		}
+	}
+	for len(queue) != 0 {
+		st := queue[0]
+		queue = queue[1:]
+		for _, to := range edges[st] {
+			if !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	active := make(map[*State]bool, len(reached))
+	for st := range reached {
+		for s := st; s != nil; s = s.parent {
+			active[s] = true
+		}
+	}
+	for _, st := range fsm.states {
+		if !active[st] {
+			report.Unreachable = append(report.Unreachable, st.State)
+		}
+		if reached[st] && !st.terminal && len(edges[st]) == 0 {
+			report.DeadEnds = append(report.DeadEnds, st.State)
+		}
+	}
+	sort.Strings(report.Unreachable)
+	sort.Strings(report.DeadEnds)
+
+	for _, etList := range fsm.events {
+		for elem := etList.Front(); elem != nil; elem = elem.Next() {
+			et := elem.Value.(*Event)
+			if fsm.states[et.From.State] != et.From || fsm.states[et.To.State] != et.To {
+				report.Dangling = append(report.Dangling, et)
+			}
+		}
+	}
+	for _, ets := range fsm.anyEvents {
+		for _, et := range ets {
+			if fsm.states[et.To.State] != et.To {
+				report.Dangling = append(report.Dangling, et)
+			}
+		}
+	}
+	sort.Slice(report.Dangling, func(i, j int) bool {
+		return report.Dangling[i].Event < report.Dangling[j].Event
+	})
+
+	report.Traps = traps(fsm.states, edges)
+	return report
+}
+
+// target is where et leads when emitted in st, internal events stay in st.
+func target(st *State, et *Event) *State {
+	if et.internal {
+		return st
+	}
+	return et.To
+}
+
+// traps finds the strongly connected components without exit using
+// Tarjan's algorithm.
+func traps(states map[string]*State, edges map[*State][]*State) [][]string {
+	index := 0
+	indices := make(map[*State]int, len(states))
+	lowlink := make(map[*State]int, len(states))
+	onStack := make(map[*State]bool, len(states))
+	stack := []*State{}
+	result := [][]string{}
+
+	var connect func(st *State)
+	connect = func(st *State) {
+		indices[st] = index
+		lowlink[st] = index
+		index++
+		stack = append(stack, st)
+		onStack[st] = true
+		for _, to := range edges[st] {
+			if _, ok := indices[to]; !ok {
+				connect(to)
+				if lowlink[to] < lowlink[st] {
+					lowlink[st] = lowlink[to]
+				}
+			} else if onStack[to] && indices[to] < lowlink[st] {
+				lowlink[st] = indices[to]
+			}
+		}
+		if lowlink[st] != indices[st] {
+			return
+		}
+		scc := map[*State]bool{}
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc[top] = true
+			if top == st {
+				break
+			}
+		}
+		cyclic, exit, terminal := len(scc) > 1, false, false
+		names := make([]string, 0, len(scc))
+		for member := range scc {
+			names = append(names, member.State)
+			terminal = terminal || member.terminal
+			for _, to := range edges[member] {
+				if to == member {
+					cyclic = true
+				}
+				if !scc[to] {
+					exit = true
+				}
+			}
+		}
+		if cyclic && !exit && !terminal {
+			sort.Strings(names)
+			result = append(result, names)
+		}
+	}
+
+	sorted := make([]*State, 0, len(states))
+	for _, st := range states {
+		sorted = append(sorted, st)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].State < sorted[j].State })
+	for _, st := range sorted {
+		if _, ok := indices[st]; !ok {
+			connect(st)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
@@ -0,0 +1,129 @@
+package yafsm
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeClean(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	c := fsm.AddState(stateC)
+	fsm.AddEvent(evAB, a, b)
+	fsm.AddEvent(evBC, b, c)
+	fsm.AddEvent(evCA, c, a)
+	// the lifecycle loops forever unless one of its states is terminal
+	if err := fsm.Validate(); !errors.Is(err, ErrStateTrap) {
+		t.Fatalf("want ErrStateTrap, got %v", err)
+	}
+	c.MarkTerminal()
+	if report := fsm.Analyze(); !report.OK() {
+		t.Fatalf("unexpected findings: %+v", report)
+	}
+	if err := fsm.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyzeFindings(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	c := fsm.AddState(stateC)
+	fini := fsm.AddState("fini")
+	fsm.AddState("orphan")
+	loop1 := fsm.AddState("loop1")
+	loop2 := fsm.AddState("loop2")
+	fsm.AddEvent(evAB, a, b)
+	fsm.AddEvent(evBC, b, c)
+	fsm.AddEvent("fini", b, fini)
+	fsm.AddEvent("loop", a, loop1)
+	fsm.AddEvent("next", loop1, loop2)
+	fsm.AddEvent("next", loop2, loop1)
+	fini.MarkTerminal()
+
+	// re-adding c leaves b->c pointing at the old state
+	fsm.DelState(stateC)
+	c = fsm.AddState(stateC)
+	fsm.AddEvent(evCA, c, a)
+	stale := &Event{Event: "stale", From: b, To: NewState(stateC)}
+	fsm.events["stale"] = listOf(stale)
+
+	report := fsm.Analyze()
+	if !reflect.DeepEqual(report.Unreachable, []string{stateC, "orphan"}) {
+		t.Errorf("Unreachable = %v", report.Unreachable)
+	}
+	if len(report.DeadEnds) != 0 {
+		t.Errorf("DeadEnds = %v", report.DeadEnds)
+	}
+	if len(report.Dangling) != 1 || report.Dangling[0] != stale {
+		t.Errorf("Dangling = %v", report.Dangling)
+	}
+	if !reflect.DeepEqual(report.Traps, [][]string{{"loop1", "loop2"}}) {
+		t.Errorf("Traps = %v", report.Traps)
+	}
+
+	err := fsm.Validate()
+	for _, target := range []error{ErrStateUnreachable, ErrEventDangling, ErrStateTrap} {
+		if !errors.Is(err, target) {
+			t.Errorf("Validate should report %v: %v", target, err)
+		}
+	}
+}
+
+func TestAnalyzeDeadEndAndSelfLoop(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	c := fsm.AddState(stateC)
+	fsm.AddEvent(evAB, a, b)
+	fsm.AddEvent("b->c", a, c)
+	fsm.AddInternalEvent("tick", c)
+
+	report := fsm.Analyze()
+	if !reflect.DeepEqual(report.DeadEnds, []string{stateB}) {
+		t.Errorf("DeadEnds = %v", report.DeadEnds)
+	}
+	if !reflect.DeepEqual(report.Traps, [][]string{{stateC}}) {
+		t.Errorf("Traps = %v", report.Traps)
+	}
+	b.MarkTerminal()
+	c.MarkTerminal()
+	if report := fsm.Analyze(); !report.OK() {
+		t.Fatalf("terminal states should be accepted: %+v", report)
+	}
+}
+
+func TestAnalyzeHierarchyAndFromAny(t *testing.T) {
+	trace := []string{}
+	fsm := newConnFSM(t, &trace)
+	fsm.GetState("idle").MarkTerminal()
+	// parent states count as reached through their children, inherited
+	// events count as outgoing
+	if report := fsm.Analyze(); !report.OK() {
+		t.Fatalf("unexpected findings: %+v", report)
+	}
+
+	fsm = NewFSM()
+	a := fsm.Init(stateA)
+	fsm.AddState(stateB)
+	fsm.AddEventFromAny("reset", a)
+	report := fsm.Analyze()
+	if !reflect.DeepEqual(report.Unreachable, []string{stateB}) {
+		t.Errorf("Unreachable = %v", report.Unreachable)
+	}
+	if !reflect.DeepEqual(report.Traps, [][]string{{stateA}}) {
+		t.Errorf("Traps = %v", report.Traps)
+	}
+}
+
+func listOf(ets ...*Event) *list.List {
+	l := list.New()
+	for _, et := range ets {
+		l.PushBack(et)
+	}
+	return l
+}
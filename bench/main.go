@@ -9,6 +9,7 @@ import (
 
 	"github.com/jumboframes/armorigo/sigaction"
 	"github.com/singchia/yafsm"
+	"github.com/singchia/yafsm/metrics"
 )
 
 const (
@@ -43,6 +44,7 @@ func initFSM(fsm *yafsm.FSM) {
 	closehalf := fsm.AddState(CLOSE_HALF)
 	closed := fsm.AddState(CLOSED)
 	fini := fsm.AddState(FINI)
+	fini.MarkFinal()
 	fsm.SetState(INIT)
 
 	// events
@@ -80,6 +82,8 @@ func initFSM(fsm *yafsm.FSM) {
 }
 
 func main() {
+	collector := metrics.New()
+	http.Handle("/metrics", collector)
 	go func() {
 		http.ListenAndServe("0.0.0.0:6061", nil)
 	}()
@@ -90,11 +94,13 @@ func main() {
 		fsm := yafsm.NewFSM()
 		fsms = append(fsms, fsm)
 		initFSM(fsm)
+		collector.Register(fsm)
 		fsm.EmitEvent(ET_CONNRECV)
 		fsm.EmitEvent(ET_CLOSESENT)
 		fsm.EmitEvent(ET_ERROR)
 		fsm.EmitEvent(ET_FINI)
 		fsm.Close()
+		collector.Unregister(fsm)
 	}
 	fmt.Println("done")
 
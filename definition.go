@@ -0,0 +1,369 @@
+package yafsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// AnyState in an EventDefinition's From registers the event with
+// AddEventFromAny.
+const AnyState = "*"
+
+// Definition is the serialisable form of an FSM. It's decoded from JSON by
+// LoadDefinition and from YAML by yamldef.LoadDefinition, other formats can be
+// decoded into it by the caller and built with NewFSMFromDefinition.
+type Definition struct {
+	Initial string             `json:"initial" yaml:"initial"`
+	States  []StateDefinition  `json:"states" yaml:"states"`
+	Regions []RegionDefinition `json:"regions,omitempty" yaml:"regions,omitempty"`
+	Events  []EventDefinition  `json:"events" yaml:"events"`
+}
+
+type StateDefinition struct {
+	Name   string `json:"name" yaml:"name"`
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"`
+	// Region must match the parent's region, if any
+	Region string   `json:"region,omitempty" yaml:"region,omitempty"`
+	Enter  []string `json:"enter,omitempty" yaml:"enter,omitempty"`
+	Leave  []string `json:"leave,omitempty" yaml:"leave,omitempty"`
+	// Final implies Terminal, see State.MarkFinal
+	Final    bool               `json:"final,omitempty" yaml:"final,omitempty"`
+	Terminal bool               `json:"terminal,omitempty" yaml:"terminal,omitempty"`
+	Timeout  *TimeoutDefinition `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// TimeoutDefinition is a State.SetTimeout, After is parsed by
+// time.ParseDuration.
+type TimeoutDefinition struct {
+	After string `json:"after" yaml:"after"`
+	Event string `json:"event" yaml:"event"`
+}
+
+type RegionDefinition struct {
+	Name    string `json:"name" yaml:"name"`
+	Initial string `json:"initial" yaml:"initial"`
+}
+
+type EventDefinition struct {
+	Name     string   `json:"name" yaml:"name"`
+	From     []string `json:"from" yaml:"from"`
+	To       string   `json:"to" yaml:"to"`
+	Internal bool     `json:"internal,omitempty" yaml:"internal,omitempty"`
+	Guards   []string `json:"guards,omitempty" yaml:"guards,omitempty"`
+	Handlers []string `json:"handlers,omitempty" yaml:"handlers,omitempty"`
+}
+
+// Registry resolves the handler names used in a Definition.
+type Registry struct {
+	stateHandlers map[string]StateHandlerE
+	eventHandlers map[string]EventHandlerE
+	guards        map[string]GuardHandler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		stateHandlers: make(map[string]StateHandlerE),
+		eventHandlers: make(map[string]EventHandlerE),
+		guards:        make(map[string]GuardHandler),
+	}
+}
+
+// AddStateHandler registers a handler usable as enter or leave hook.
+func (r *Registry) AddStateHandler(name string, handler StateHandlerE) {
+	r.stateHandlers[name] = handler
+}
+
+func (r *Registry) AddEventHandler(name string, handler EventHandlerE) {
+	r.eventHandlers[name] = handler
+}
+
+func (r *Registry) AddGuard(name string, guard GuardHandler) {
+	r.guards[name] = guard
+}
+
+// LoadDefinition decodes a JSON Definition from reader and builds it, see
+// NewFSMFromDefinition.
+func LoadDefinition(reader io.Reader, registry *Registry, opts ...FSMOption) (*FSM, error) {
+	def := &Definition{}
+	dec := json.NewDecoder(reader)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(def); err != nil {
+		return nil, err
+	}
+	return NewFSMFromDefinition(def, registry, opts...)
+}
+
+// NewFSMFromDefinition builds an FSM from def, resolving handler names in
+// registry, which may be nil if def names none. All problems found are
+// reported at once, joined in the returned error.
+func NewFSMFromDefinition(def *Definition, registry *Registry, opts ...FSMOption) (*FSM, error) {
+	if registry == nil {
+		registry = NewRegistry()
+	}
+	fsm := NewFSM(opts...)
+	errs := []error{}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	defined := make(map[string]StateDefinition, len(def.States))
+	for _, sd := range def.States {
+		if _, ok := defined[sd.Name]; ok {
+			fail("state %q: %w", sd.Name, ErrStateDuplicated)
+			continue
+		}
+		defined[sd.Name] = sd
+	}
+	for _, rd := range def.Regions {
+		sd, ok := defined[rd.Initial]
+		if !ok {
+			fail("region %q: initial %q: %w", rd.Name, rd.Initial, ErrStateNotExist)
+			continue
+		}
+		if sd.Region != rd.Name {
+			fail("region %q: initial %q: %w", rd.Name, rd.Initial, ErrRegionMismatch)
+			continue
+		}
+		if _, err := fsm.AddRegion(rd.Name, rd.Initial); err != nil {
+			fail("region %q: %w", rd.Name, err)
+		}
+	}
+	// create every state first so parents may be defined in any order
+	for _, sd := range def.States {
+		if sd.Region == "" {
+			fsm.AddState(sd.Name)
+		} else if _, err := fsm.AddRegionState(sd.Region, sd.Name); err != nil {
+			fail("state %q: region %q: %w", sd.Name, sd.Region, err)
+		}
+	}
+	for _, sd := range def.States {
+		st := fsm.GetState(sd.Name)
+		if st == nil {
+			continue
+		}
+		if sd.Parent != "" {
+			parent := fsm.GetState(sd.Parent)
+			if parent == nil {
+				fail("state %q: parent %q: %w", sd.Name, sd.Parent, ErrStateNotExist)
+			} else if _, err := fsm.AddSubState(parent, sd.Name); err != nil {
+				fail("state %q: parent %q: %w", sd.Name, sd.Parent, err)
+			}
+		}
+		for _, name := range sd.Enter {
+			handler, ok := registry.stateHandlers[name]
+			if !ok {
+				fail("state %q: enter %q: %w", sd.Name, name, ErrHandlerNotExist)
+				continue
+			}
+			st.AddEnterE(handler)
+			st.enterNames = append(st.enterNames, name)
+		}
+		for _, name := range sd.Leave {
+			handler, ok := registry.stateHandlers[name]
+			if !ok {
+				fail("state %q: leave %q: %w", sd.Name, name, ErrHandlerNotExist)
+				continue
+			}
+			st.AddLeftE(handler)
+			st.leftNames = append(st.leftNames, name)
+		}
+		if sd.Final {
+			st.MarkFinal()
+		}
+		if sd.Terminal {
+			st.MarkTerminal()
+		}
+		if sd.Timeout != nil {
+			d, err := time.ParseDuration(sd.Timeout.After)
+			if err != nil {
+				fail("state %q: timeout: %w", sd.Name, err)
+			} else if d <= 0 {
+				fail("state %q: timeout %q: not positive", sd.Name, sd.Timeout.After)
+			} else {
+				st.SetTimeout(d, sd.Timeout.Event)
+			}
+		}
+	}
+	if sd, ok := defined[def.Initial]; !ok {
+		fail("initial %q: %w", def.Initial, ErrStateNotExist)
+	} else if sd.Region != "" {
+		fail("initial %q: %w", def.Initial, ErrRegionMismatch)
+	} else {
+		fsm.Init(def.Initial)
+	}
+
+	for _, ed := range def.Events {
+		ets, edErrs := fsm.defineEvent(ed)
+		if len(edErrs) != 0 {
+			errs = append(errs, edErrs...)
+			continue
+		}
+		for _, name := range ed.Guards {
+			guard, ok := registry.guards[name]
+			if !ok {
+				fail("event %q: guard %q: %w", ed.Name, name, ErrHandlerNotExist)
+				continue
+			}
+			for _, et := range ets {
+				et.AddGuard(guard)
+				et.guardNames = append(et.guardNames, name)
+			}
+		}
+		for _, name := range ed.Handlers {
+			handler, ok := registry.eventHandlers[name]
+			if !ok {
+				fail("event %q: handler %q: %w", ed.Name, name, ErrHandlerNotExist)
+				continue
+			}
+			for _, et := range ets {
+				et.AddHandlerE(handler)
+				et.handlerNames = append(et.handlerNames, name)
+			}
+		}
+	}
+	for _, sd := range def.States {
+		if sd.Timeout != nil && !fsm.eventExists(sd.Timeout.Event) {
+			fail("state %q: timeout event %q: %w", sd.Name, sd.Timeout.Event, ErrEventNotExist)
+		}
+	}
+	if len(errs) != 0 {
+		fsm.Close()
+		return nil, errors.Join(errs...)
+	}
+	return fsm, nil
+}
+
+// defineEvent adds the events of ed, reporting every state it can't resolve
+// at once.
+func (fsm *FSM) defineEvent(ed EventDefinition) ([]*Event, []error) {
+	if len(ed.From) == 0 {
+		return nil, []error{fmt.Errorf("event %q: no from: %w", ed.Name, ErrStateNotExist)}
+	}
+	if ed.Internal && len(ed.From) == 1 && ed.From[0] == AnyState {
+		// internal transitions stay in a given state
+		return nil, []error{fmt.Errorf("event %q: internal from %q: %w", ed.Name, AnyState, ErrEventIllegal)}
+	}
+	errs := []error{}
+	to := fsm.GetState(ed.To)
+	if to == nil && !(ed.Internal && ed.To == "") {
+		errs = append(errs, fmt.Errorf("event %q: to %q: %w", ed.Name, ed.To, ErrStateNotExist))
+	}
+	if len(ed.From) == 1 && ed.From[0] == AnyState {
+		if len(errs) != 0 {
+			return nil, errs
+		}
+		et, err := fsm.AddEventFromAny(ed.Name, to)
+		if err != nil {
+			return nil, []error{fmt.Errorf("event %q: from %q: %w", ed.Name, AnyState, err)}
+		}
+		return []*Event{et}, nil
+	}
+	froms := make([]*State, 0, len(ed.From))
+	for _, name := range ed.From {
+		from := fsm.GetState(name)
+		if from == nil {
+			errs = append(errs, fmt.Errorf("event %q: from %q: %w", ed.Name, name, ErrStateNotExist))
+			continue
+		}
+		if ed.Internal && to != nil && to != from {
+			errs = append(errs, fmt.Errorf("event %q: internal from %q to %q: %w",
+				ed.Name, from.State, ed.To, ErrEventIllegal))
+			continue
+		}
+		froms = append(froms, from)
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	if ed.Internal {
+		ets := make([]*Event, 0, len(froms))
+		for _, from := range froms {
+			et, err := fsm.AddInternalEvent(ed.Name, from)
+			if err != nil {
+				return nil, []error{fmt.Errorf("event %q: from %q: %w", ed.Name, from.State, err)}
+			}
+			ets = append(ets, et)
+		}
+		return ets, nil
+	}
+	ets, err := fsm.AddEventFrom(ed.Name, froms, to)
+	if err != nil {
+		return nil, []error{fmt.Errorf("event %q: from %v to %q: %w", ed.Name, ed.From, ed.To, err)}
+	}
+	return ets, nil
+}
+
+// ExportDefinition returns the definition of fsm, states and events sorted by
+// name. Only hooks added through a Definition have a name and are exported.
+func (fsm *FSM) ExportDefinition() *Definition {
+	fsm.mutex.RLock()
+	defer fsm.mutex.RUnlock()
+
+	def := &Definition{
+		Initial: fsm.initials[""],
+		States:  []StateDefinition{},
+		Events:  []EventDefinition{},
+	}
+	for _, region := range fsm.regionOrder {
+		def.Regions = append(def.Regions, RegionDefinition{
+			Name:    region,
+			Initial: fsm.initials[region],
+		})
+	}
+	for _, st := range fsm.states {
+		sd := StateDefinition{
+			Name:     st.State,
+			Region:   st.region,
+			Enter:    st.enterNames,
+			Leave:    st.leftNames,
+			Final:    st.final,
+			Terminal: st.terminal,
+		}
+		if st.parent != nil {
+			sd.Parent = st.parent.State
+		}
+		if st.timeout > 0 {
+			sd.Timeout = &TimeoutDefinition{After: st.timeout.String(), Event: st.timeoutEvent}
+		}
+		def.States = append(def.States, sd)
+	}
+	sort.Slice(def.States, func(i, j int) bool {
+		return def.States[i].Name < def.States[j].Name
+	})
+
+	export := func(et *Event, from string) {
+		def.Events = append(def.Events, EventDefinition{
+			Name:     et.Event,
+			From:     []string{from},
+			To:       et.To.State,
+			Internal: et.internal,
+			Guards:   et.guardNames,
+			Handlers: et.handlerNames,
+		})
+	}
+	for _, etList := range fsm.events {
+		for elem := etList.Front(); elem != nil; elem = elem.Next() {
+			et := elem.Value.(*Event)
+			export(et, et.From.State)
+		}
+	}
+	for _, ets := range fsm.anyEvents {
+		for _, et := range ets {
+			export(et, AnyState)
+		}
+	}
+	sort.Slice(def.Events, func(i, j int) bool {
+		if def.Events[i].Name != def.Events[j].Name {
+			return def.Events[i].Name < def.Events[j].Name
+		}
+		if def.Events[i].From[0] != def.Events[j].From[0] {
+			return def.Events[i].From[0] < def.Events[j].From[0]
+		}
+		return def.Events[i].To < def.Events[j].To
+	})
+	return def
+}
@@ -0,0 +1,225 @@
+package yafsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const connDefinition = `{
+	"initial": "init",
+	"states": [
+		{"name": "init", "leave": ["trace"]},
+		{"name": "conned", "enter": ["trace"]},
+		{"name": "closing", "parent": "conned"},
+		{"name": "closed"}
+	],
+	"events": [
+		{"name": "connack", "from": ["init"], "to": "conned", "guards": ["allow"], "handlers": ["count"]},
+		{"name": "close", "from": ["conned"], "to": "closing"},
+		{"name": "error", "from": ["init", "conned"], "to": "closed"},
+		{"name": "fini", "from": ["*"], "to": "closed"},
+		{"name": "ping", "from": ["conned"], "internal": true}
+	]
+}`
+
+func newConnRegistry(trace *[]string) *Registry {
+	registry := NewRegistry()
+	registry.AddStateHandler("trace", func(_ context.Context, st *State) error {
+		*trace = append(*trace, st.State)
+		return nil
+	})
+	registry.AddEventHandler("count", func(_ context.Context, et *Event) error {
+		*trace = append(*trace, "count")
+		return nil
+	})
+	registry.AddGuard("allow", func(context.Context, *Event) error { return nil })
+	return registry
+}
+
+func TestLoadDefinition(t *testing.T) {
+	trace := []string{}
+	fsm, err := LoadDefinition(strings.NewReader(connDefinition), newConnRegistry(&trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsm.State() != "init" {
+		t.Fatalf("initial state %q", fsm.State())
+	}
+	for _, ev := range []string{"connack", "ping", "close", "error"} {
+		if err := fsm.EmitEvent(ev); err != nil {
+			t.Fatalf("%s: %v", ev, err)
+		}
+	}
+	// error is inherited by closing from conned
+	if fsm.State() != "closed" {
+		t.Fatalf("expected closed, got %q", fsm.State())
+	}
+	// close is external: conned is left and re-entered on the way to closing
+	want := []string{"init", "count", "conned", "conned"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+	if err := fsm.EmitEvent("fini"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadDefinitionReportsAllErrors(t *testing.T) {
+	def := `{
+		"initial": "missing",
+		"states": [
+			{"name": "a", "enter": ["nope"]},
+			{"name": "a"},
+			{"name": "b", "parent": "ghost"}
+		],
+		"events": [
+			{"name": "go", "from": ["a"], "to": "b"},
+			{"name": "go", "from": ["a"], "to": "a"},
+			{"name": "back", "from": ["b"], "to": "z"},
+			{"name": "x", "from": ["b"], "to": "a", "handlers": ["unknown"]}
+		]
+	}`
+	fsm, err := LoadDefinition(strings.NewReader(def), nil)
+	if fsm != nil {
+		t.Fatal("no FSM expected on error")
+	}
+	for _, target := range []error{ErrStateNotExist, ErrStateDuplicated, ErrHandlerNotExist, ErrEventIllegal} {
+		if !errors.Is(err, target) {
+			t.Errorf("error should include %v: %v", target, err)
+		}
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 7 {
+		t.Errorf("want 7 errors, got %d: %v", n, err)
+	}
+}
+
+func TestLoadDefinitionEventErrors(t *testing.T) {
+	def := `{
+		"initial": "a",
+		"states": [{"name": "a"}],
+		"events": [{"name": "go", "from": ["x", "a", "y"], "to": "z"}]
+	}`
+	_, err := LoadDefinition(strings.NewReader(def), nil)
+	if err == nil {
+		t.Fatal("want errors")
+	}
+	for _, name := range []string{`to "z"`, `from "x"`, `from "y"`} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("%s not reported: %v", name, err)
+		}
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+		t.Errorf("want 3 errors, got %d: %v", n, err)
+	}
+}
+
+func TestLoadDefinitionStateFlags(t *testing.T) {
+	def := `{
+		"initial": "a",
+		"states": [
+			{"name": "a", "timeout": {"after": "30s", "event": "expire"}},
+			{"name": "b", "terminal": true},
+			{"name": "c", "final": true}
+		],
+		"events": [
+			{"name": "expire", "from": ["a"], "to": "b"},
+			{"name": "go", "from": ["b"], "to": "c"}
+		]
+	}`
+	fsm, err := LoadDefinition(strings.NewReader(def), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, event := fsm.GetState("a").Timeout(); d != 30*time.Second || event != "expire" {
+		t.Fatalf("timeout %v %q", d, event)
+	}
+	if b, c := fsm.GetState("b"), fsm.GetState("c"); !b.Terminal() || b.Final() || !c.Final() || !c.Terminal() {
+		t.Fatal("flags not loaded")
+	}
+	states := fsm.ExportDefinition().States
+	if *states[0].Timeout != (TimeoutDefinition{After: "30s", Event: "expire"}) ||
+		!states[1].Terminal || !states[2].Final {
+		t.Fatalf("flags not exported: %+v", states)
+	}
+
+	for _, timeout := range []string{
+		`{"after": "soon", "event": "expire"}`,
+		`{"after": "0s", "event": "expire"}`,
+		`{"after": "1s", "event": "missing"}`,
+	} {
+		bad := strings.Replace(def, `{"after": "30s", "event": "expire"}`, timeout, 1)
+		if _, err := LoadDefinition(strings.NewReader(bad), nil); err == nil {
+			t.Errorf("%s: want error", timeout)
+		}
+	}
+}
+
+func TestLoadDefinitionInternalFromAny(t *testing.T) {
+	for _, event := range []string{
+		`{"name": "tick", "from": ["*"], "internal": true}`,
+		`{"name": "tick", "from": ["*"], "to": "a", "internal": true}`,
+	} {
+		def := `{
+			"initial": "a",
+			"states": [{"name": "a"}],
+			"events": [` + event + `, {"name": "go", "from": ["a"], "to": "z"}]
+		}`
+		fsm, err := LoadDefinition(strings.NewReader(def), nil)
+		if fsm != nil || !errors.Is(err, ErrEventIllegal) || !errors.Is(err, ErrStateNotExist) {
+			t.Fatalf("%s: want ErrEventIllegal among the errors, got %v", event, err)
+		}
+	}
+}
+
+func TestLoadDefinitionRegions(t *testing.T) {
+	def := &Definition{
+		Initial: "down",
+		States: []StateDefinition{
+			{Name: "down"}, {Name: "up"},
+			{Name: "anonymous", Region: "auth"}, {Name: "authenticated", Region: "auth"},
+		},
+		Regions: []RegionDefinition{{Name: "auth", Initial: "anonymous"}},
+		Events: []EventDefinition{
+			{Name: "login", From: []string{"anonymous"}, To: "authenticated"},
+		},
+	}
+	fsm, err := NewFSMFromDefinition(def, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent("login"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "authenticated"}) {
+		t.Fatalf("ActiveStates = %v", got)
+	}
+}
+
+func TestExportDefinitionRoundTrip(t *testing.T) {
+	trace := []string{}
+	fsm, err := LoadDefinition(strings.NewReader(connDefinition), newConnRegistry(&trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := fsm.ExportDefinition()
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(exported); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadDefinition(buf, newConnRegistry(&trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reloaded.ExportDefinition(), exported) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", reloaded.ExportDefinition(), exported)
+	}
+	if len(exported.Events) != 6 || exported.Events[0].Name != "close" {
+		t.Fatalf("unexpected events: %+v", exported.Events)
+	}
+}
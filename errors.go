@@ -1,6 +1,9 @@
 package yafsm
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrEventDuplicated      = errors.New("event duplicated")
@@ -8,4 +11,47 @@ var (
 	ErrEventNotExist        = errors.New("event does not exist")
 	ErrStateNotExist        = errors.New("state does not exist")
 	ErrIllegalStateForEvent = errors.New("illegal state for event")
+	ErrGuardRejected        = errors.New("guard rejected")
+	ErrStateCycle           = errors.New("state hierarchy cycle")
+	ErrRegionDuplicated     = errors.New("region duplicated")
+	ErrRegionNotExist       = errors.New("region does not exist")
+	ErrRegionMismatch       = errors.New("states belong to different regions")
+	ErrStateDuplicated      = errors.New("state duplicated")
+	ErrHandlerNotExist      = errors.New("handler does not exist")
+	ErrStateUnreachable     = errors.New("state unreachable")
+	ErrStateDeadEnd         = errors.New("state is a dead end")
+	ErrStateTrap            = errors.New("states form a cycle without exit")
+	ErrEventDangling        = errors.New("event refers to a removed state")
+	ErrFSMDone              = errors.New("fsm completed")
+	ErrFSMClosed            = errors.New("fsm closed")
+	ErrStore                = errors.New("store failed")
+	ErrReplayDiverged       = errors.New("replay diverged from the log")
+	ErrSnapshotMismatch     = errors.New("snapshot doesn't match the definition")
+	ErrTimerCancelled       = errors.New("timer cancelled")
+)
+
+type Phase string
+
+const (
+	PhaseLeave Phase = "leave"
+	PhaseEvent Phase = "event"
+	PhaseEnter Phase = "enter"
 )
+
+// HandlerError identifies the handler that failed a transition, Name is the
+// state for leave/enter handlers and the event for event handlers, Index is
+// the handler's position in the order it was added.
+type HandlerError struct {
+	Phase Phase
+	Name  string
+	Index int
+	Err   error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%s handler #%d of %q: %v", e.Phase, e.Index, e.Name, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
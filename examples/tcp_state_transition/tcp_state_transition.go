@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/singchia/yafsm"
+	"github.com/singchia/yafsm/yafsmtest"
 )
 
 /*
@@ -86,10 +87,14 @@ const (
 	ET_RECVFINACK2 = "recvfinack2" // CLOSING -> TIME_WAIT
 	ET_RECVFINACK3 = "recvfinack3" // LAST_ACK -> CLOSED
 	ET_TIMEWAITOUT = "timewaitout" // TIME_WAIT -> CLOSED
+
+	// timeouts
+	SYN_TIMEOUT = 75 * time.Second
+	MSL         = 2 * time.Minute
 )
 
-func initFSM() (*yafsm.FSM, error) {
-	fsm := yafsm.NewFSM()
+func initFSM(opts ...yafsm.FSMOption) (*yafsm.FSM, error) {
+	fsm := yafsm.NewFSM(opts...)
 	// states
 	closed := fsm.Init(CLOSED)
 	synsent := fsm.AddState(SYN_SENT)
@@ -101,6 +106,10 @@ func initFSM() (*yafsm.FSM, error) {
 	closewait := fsm.AddState(CLOSE_WAIT)
 	lastack := fsm.AddState(LAST_ACK)
 
+	// armed on enter, cancelled on leave
+	synsent.SetTimeout(SYN_TIMEOUT, ET_SYNTIMEOUT)
+	timewait.SetTimeout(2*MSL, ET_TIMEWAITOUT)
+
 	{
 		_, err := fsm.AddEvent(ET_SENDSYN, closed, synsent)
 		if err != nil {
@@ -355,6 +364,21 @@ func emitAbnormal(t *testing.T, fsm *yafsm.FSM) error {
 	return nil
 }
 
+func emitTimeout(t *testing.T, fsm *yafsm.FSM, clock *yafsmtest.Clock) error {
+	// CLOSED -> SYN_SENT -> (no SYN,ACK) -> CLOSED
+	err := fsm.EmitEvent(ET_SENDSYN)
+	if err != nil {
+		return err
+	}
+	t.Log(fsm.State())
+	clock.Advance(SYN_TIMEOUT)
+	if fsm.State() != CLOSED {
+		return errors.New("syn didn't time out")
+	}
+	t.Log(fsm.State())
+	return nil
+}
+
 func emitPrio(t *testing.T, fsm *yafsm.FSM) error {
 	err := error(nil)
 	ets := fsm.GetEvents(ET_SENDSYN)
@@ -549,6 +573,18 @@ func TestFSM(t *testing.T) {
 		return
 	}
 	t.Log("================")
+	clock := yafsmtest.NewClock(time.Now())
+	fsm, err = initFSM(yafsm.WithClock(clock))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = emitTimeout(t, fsm, clock)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	t.Log("================")
 	fsm, err = initFSM()
 	if err != nil {
 		t.Error(err)
@@ -0,0 +1,266 @@
+// Package filestore is a yafsm.Store keeping a snapshot file and an
+// append-only log of JSON lines in a directory.
+package filestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/singchia/yafsm"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "log.jsonl"
+)
+
+var (
+	ErrCorrupted = errors.New("log corrupted")
+	// ErrBroken is returned by Append once a failed append couldn't be
+	// undone, until the store is opened again.
+	ErrBroken = errors.New("log broken")
+)
+
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs the log on every Append, the default.
+	SyncAlways SyncPolicy = iota
+	// SyncNever leaves flushing to the OS, entries appended shortly before
+	// a machine crash may be lost. A process crash loses nothing.
+	SyncNever
+)
+
+type Option func(*Store)
+
+func WithSync(policy SyncPolicy) Option {
+	return func(s *Store) {
+		s.sync = policy
+	}
+}
+
+var _ yafsm.Store = (*Store)(nil)
+
+type Store struct {
+	dir   string
+	sync  SyncPolicy
+	mutex sync.Mutex
+	log   *os.File
+	// set when a failed append left a partial line behind
+	broken error
+}
+
+// writeLog is replaced by tests to fail writes.
+var writeLog = (*os.File).Write
+
+// Open opens or creates the store in dir. A partially written last entry,
+// left by a crash during Append, is dropped.
+func Open(dir string, opts ...Option) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, log: log}
+	for _, opt := range opts {
+		opt(s)
+	}
+	_, size, err := s.scan()
+	if err == nil {
+		err = s.truncate(size)
+	}
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// scan reads the log, returning its entries and the size they span. A last
+// line without newline is a torn write and ignored, any other undecodable
+// line is corruption.
+func (s *Store) scan() ([]yafsm.Entry, int64, error) {
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	entries := []yafsm.Entry{}
+	reader := bufio.NewReader(s.log)
+	size := int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// torn or no tail
+			return entries, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		entry := yafsm.Entry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, 0, fmt.Errorf("%w: offset %d: %w", ErrCorrupted, size, err)
+		}
+		entries = append(entries, entry)
+		size += int64(len(line))
+	}
+}
+
+func (s *Store) truncate(size int64) error {
+	info, err := s.log.Stat()
+	if err != nil || info.Size() == size {
+		return err
+	}
+	if err := s.log.Truncate(size); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// Load returns the snapshot, nil if none was saved, and the entries appended
+// since. Args come back as decoded by encoding/json.
+func (s *Store) Load() (*yafsm.Snapshot, []yafsm.Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := (*yafsm.Snapshot)(nil)
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if err == nil {
+		snapshot = &yafsm.Snapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return nil, nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	entries, _, err := s.scan()
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, entries, nil
+}
+
+// Save replaces the snapshot atomically, then drops the entries it covers
+// from the log.
+func (s *Store) Save(snapshot *yafsm.Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.replace(snapshotFile, data); err != nil {
+		return err
+	}
+	entries, _, err := s.scan()
+	if err != nil {
+		return err
+	}
+	kept := []byte{}
+	for _, entry := range entries {
+		if snapshot.Covers(entry) {
+			continue
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		kept = append(append(kept, line...), '\n')
+	}
+	if len(kept) == 0 {
+		return s.truncate(0)
+	}
+	// entries appended after the snapshot was taken
+	if err := s.replace(logFile, kept); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.log.Close()
+	s.log = log
+	return nil
+}
+
+// replace writes data to name atomically.
+func (s *Store) replace(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+func (s *Store) Append(entry yafsm.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.broken != nil {
+		return fmt.Errorf("%w: %w", ErrBroken, s.broken)
+	}
+	info, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = writeLog(s.log, append(data, '\n'))
+	if err == nil && s.sync == SyncAlways {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		// a partial line would be glued to the next entry
+		if terr := s.truncate(info.Size()); terr != nil {
+			s.broken = terr
+			return errors.Join(err, fmt.Errorf("%w: %w", ErrBroken, terr))
+		}
+	}
+	return err
+}
+
+// Sync flushes the log, for use with SyncNever.
+func (s *Store) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.log.Sync()
+}
+
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.log.Close()
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,235 @@
+package filestore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/singchia/yafsm"
+)
+
+func newFSM(t *testing.T, store *Store, opts ...yafsm.FSMOption) *yafsm.FSM {
+	fsm := yafsm.NewFSM(append(opts, yafsm.WithStore(store))...)
+	idle := fsm.Init("idle")
+	running := fsm.AddState("running")
+	done := fsm.AddState("done")
+	for _, ev := range []struct {
+		name     string
+		from, to *yafsm.State
+	}{
+		{"start", idle, running},
+		{"stop", running, idle},
+		{"finish", running, done},
+	} {
+		if _, err := fsm.AddEvent(ev.name, ev.from, ev.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsm
+}
+
+func open(t *testing.T, dir string, opts ...Option) *Store {
+	store, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStoreRecover(t *testing.T) {
+	dir := t.TempDir()
+	fsm := newFSM(t, open(t, dir))
+	for _, event := range []string{"start", "stop", "start"} {
+		if err := fsm.EmitEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := open(t, dir)
+	snapshot, entries, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot != nil || len(entries) != 3 || entries[2].Event != "start" {
+		t.Fatalf("unexpected load %v %+v", snapshot, entries)
+	}
+	recovered := newFSM(t, store)
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if recovered.State() != "running" {
+		t.Fatalf("want running, got %s", recovered.State())
+	}
+}
+
+func TestStoreCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	fsm := newFSM(t, open(t, dir, WithSync(SyncNever)))
+	fsm.EmitEvent("start")
+	if err := fsm.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	fsm.EmitEventWithArgs("finish", "ok")
+
+	snapshot, entries, err := open(t, dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == nil || snapshot.States[""] != "running" {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Args, []interface{}{"ok"}) {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	recovered := newFSM(t, open(t, dir))
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if recovered.State() != "done" {
+		t.Fatalf("want done, got %s", recovered.State())
+	}
+}
+
+func TestStoreSaveKeepsUncovered(t *testing.T) {
+	dir := t.TempDir()
+	store := open(t, dir)
+	fsm := newFSM(t, store)
+	fsm.EmitEvent("start")
+	snapshot := fsm.Snapshot()
+	// committed after the snapshot was taken
+	fsm.EmitEvent("stop")
+	if err := store.Save(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	fsm.EmitEvent("start")
+
+	_, entries, err := open(t, dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Event != "stop" || entries[1].Event != "start" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	recovered := newFSM(t, open(t, dir))
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if recovered.State() != "running" {
+		t.Fatalf("want running, got %s", recovered.State())
+	}
+}
+
+func TestStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	fsm := newFSM(t, open(t, dir))
+	fsm.EmitEvent("start")
+	fsm.EmitEvent("stop")
+
+	// crash in the middle of appending the third entry
+	path := filepath.Join(dir, logFile)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"event":"start","prio":1,"ti`)
+	f.Close()
+
+	store := open(t, dir)
+	recovered := newFSM(t, store)
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if recovered.State() != "idle" {
+		t.Fatalf("want idle, got %s", recovered.State())
+	}
+	// the torn tail is gone, new entries follow the intact ones
+	if err := recovered.EmitEvent("start"); err != nil {
+		t.Fatal(err)
+	}
+	_, entries, err := open(t, dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].States[""] != "running" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+
+	// a write failing halfway doesn't leave its partial line behind
+	errNoSpace := errors.New("no space left on device")
+	writeLog = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, errNoSpace
+	}
+	err = recovered.EmitEvent("stop")
+	writeLog = (*os.File).Write
+	if !errors.Is(err, errNoSpace) {
+		t.Fatalf("want the write error, got %v", err)
+	}
+	if err := recovered.EmitEvent("start"); err != nil {
+		t.Fatal(err)
+	}
+	_, entries, err = open(t, dir).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[3].Event != "start" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+}
+
+func TestStoreCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	fsm := newFSM(t, open(t, dir))
+	fsm.EmitEvent("start")
+
+	path := filepath.Join(dir, logFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append([]byte("garbage\n"), data...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("want ErrCorrupted, got %v", err)
+	}
+}
+
+func TestStoreSaveCrash(t *testing.T) {
+	for _, opts := range [][]yafsm.FSMOption{nil, {yafsm.WithEventSourcing(yafsm.ReplaySilent)}} {
+		dir := t.TempDir()
+		store := open(t, dir)
+		fsm := newFSM(t, store, opts...)
+		fsm.EmitEvent("start")
+		fsm.EmitEvent("stop")
+		fsm.EmitEvent("start")
+
+		// crash after the snapshot is renamed in but before the log is emptied
+		_, entries, _ := store.Load()
+		if err := store.Save(fsm.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			store.Append(entry)
+		}
+
+		// the entries are covered by the snapshot, replaying them would fail
+		recovered := newFSM(t, open(t, dir), opts...)
+		if err := recovered.Recover(); err != nil {
+			t.Fatal(err)
+		}
+		if recovered.State() != "running" {
+			t.Fatalf("want running, got %s", recovered.State())
+		}
+		// and new entries follow them
+		recovered.EmitEvent("finish")
+		_, entries, _ = open(t, dir).Load()
+		if last := entries[len(entries)-1]; last.Event != "finish" || last.Seq != 4 {
+			t.Fatalf("unexpected entry %+v", last)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package yafsm
+
+// MarkFinal flags st as a final state, which is terminal as well. Once every
+// region rests in a final state after an emission the FSM is complete: Done
+// is closed, the completion callback runs and further emissions fail with
+// ErrFSMDone. SetState doesn't complete the FSM.
+func (st *State) MarkFinal() {
+	st.final = true
+	st.terminal = true
+}
+
+func (st *State) Final() bool {
+	return st.final
+}
+
+// WithCompletion sets a callback run once when the FSM completes, with the
+// final state the last emission entered. It runs before that emission is
+// answered and, like hooks, while the FSM is locked in WithInSeq mode.
+func WithCompletion(callback func(final *State)) FSMOption {
+	return func(fsm *FSM) {
+		fsm.completion = callback
+	}
+}
+
+// Done is closed once the FSM completes.
+func (fsm *FSM) Done() <-chan struct{} {
+	return fsm.done
+}
+
+// complete checks whether every region rests in a final state and if so
+// marks the FSM complete, returning the current state of region, the last
+// one the emission moved. fsm.mutex must be held.
+func (fsm *FSM) complete(region string) *State {
+	if fsm.completed || !fsm.final() {
+		return nil
+	}
+	fsm.completed = true
+	close(fsm.done)
+	return fsm.states[fsm.current(region)]
+}
+
+// final reports whether every region rests in a final state, fsm.mutex must
+// be held.
+func (fsm *FSM) final() bool {
+	for _, region := range fsm.regionNames() {
+		st, ok := fsm.states[fsm.current(region)]
+		if !ok || !st.final {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFinalStateCompletes(t *testing.T) {
+	completed := []string{}
+	fsm := NewFSM(WithCompletion(func(final *State) { completed = append(completed, final.State) }))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	fsm.AddEvent(evAB, a, b)
+	fsm.AddEvent("b->a", b, a)
+	b.MarkFinal()
+	if !b.Final() || !b.Terminal() {
+		t.Fatal("final states should be terminal")
+	}
+
+	select {
+	case <-fsm.Done():
+		t.Fatal("Done closed too early")
+	default:
+	}
+	if err := fsm.EmitEvent(evAB); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fsm.Done():
+	default:
+		t.Fatal("Done should be closed")
+	}
+	if len(completed) != 1 || completed[0] != stateB {
+		t.Fatalf("completion callback: %v", completed)
+	}
+	if err := fsm.EmitEvent("b->a"); !errors.Is(err, ErrFSMDone) {
+		t.Fatalf("want ErrFSMDone, got %v", err)
+	}
+	if err := <-fsm.EmitPrioEventAsync(2, "missing"); !errors.Is(err, ErrFSMDone) {
+		t.Fatalf("want ErrFSMDone, got %v", err)
+	}
+}
+
+func TestFinalStateRejectsQueuedEvents(t *testing.T) {
+	fsm := NewFSM(WithAsync())
+	defer fsm.Close()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	ab, _ := fsm.AddEvent(evAB, a, b)
+	fsm.AddEvent("b->a", b, a)
+	b.MarkFinal()
+
+	release := make(chan struct{})
+	ab.AddHandler(func(*Event) { <-release })
+	first := fsm.EmitEventAsync(evAB)
+	late := fsm.EmitEventAsync("b->a")
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-late; !errors.Is(err, ErrFSMDone) {
+		t.Fatalf("want ErrFSMDone, got %v", err)
+	}
+}
+
+func TestFinalStateRollbackDoesNotComplete(t *testing.T) {
+	fsm := NewFSM(WithFailurePolicy(FailureRollback))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	ab, _ := fsm.AddEvent(evAB, a, b)
+	b.MarkFinal()
+	ab.AddHandlerE(func(context.Context, *Event) error { return errors.New("boom") })
+	if err := fsm.EmitEvent(evAB); err == nil {
+		t.Fatal("want error")
+	}
+	select {
+	case <-fsm.Done():
+		t.Fatal("rolled back emission must not complete the FSM")
+	default:
+	}
+}
+
+func TestFinalStatesAcrossRegions(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	fsm.GetState("down").MarkFinal()
+	fsm.GetState("authenticated").MarkFinal()
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("disconnect")
+	select {
+	case <-fsm.Done():
+		t.Fatal("auth region isn't final yet")
+	default:
+	}
+	if err := fsm.EmitEvent("login"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fsm.Done():
+	default:
+		t.Fatal("every region is final, Done should be closed")
+	}
+}
+
+func TestFinalPanicState(t *testing.T) {
+	completed := (*State)(nil)
+	fsm := NewFSM(WithPanicState("abnormal"), WithCompletion(func(final *State) { completed = final }))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	fsm.AddState("abnormal").MarkFinal()
+	fsm.AddEvent(evAB, a, b)
+	b.AddEnter(func(*State) { panic("boom") })
+
+	if err := fsm.EmitEvent(evAB); err == nil {
+		t.Fatal("want panic error")
+	}
+	if completed == nil || completed.State != "abnormal" || !completed.Final() {
+		t.Fatalf("completion callback: %v", completed)
+	}
+	select {
+	case <-fsm.Done():
+	default:
+		t.Fatal("Done should be closed")
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/singchia/yafsm
 
-go 1.20
+go 1.21
 
-require github.com/jumboframes/armorigo v0.2.3
+require (
+	github.com/jumboframes/armorigo v0.2.3
+	gopkg.in/yaml.v3 v3.0.1
+)
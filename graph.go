@@ -0,0 +1,263 @@
+package yafsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type graphOptions struct {
+	current bool
+	labels  bool
+}
+
+type GraphOption func(*graphOptions)
+
+// WithCurrentHighlighted highlights the active states and their ancestors.
+func WithCurrentHighlighted() GraphOption {
+	return func(opts *graphOptions) {
+		opts.current = true
+	}
+}
+
+// WithEventLabels labels edges with event names.
+func WithEventLabels() GraphOption {
+	return func(opts *graphOptions) {
+		opts.labels = true
+	}
+}
+
+type graphEdge struct {
+	from, to *State
+	event    string
+	internal bool
+}
+
+// graph is a sorted snapshot of the states and events to render.
+type graph struct {
+	opts     graphOptions
+	ids      map[*State]string
+	byName   map[string]*State
+	children map[*State][]*State
+	roots    map[string][]*State // top level states by region
+	regions  []string
+	initials map[string]string
+	active   map[*State]bool
+	edges    []graphEdge
+}
+
+func (fsm *FSM) graph(opts []GraphOption) *graph {
+	fsm.mutex.RLock()
+	defer fsm.mutex.RUnlock()
+
+	g := &graph{
+		ids:      make(map[*State]string),
+		byName:   make(map[string]*State, len(fsm.states)),
+		children: make(map[*State][]*State),
+		roots:    make(map[string][]*State),
+		regions:  fsm.regionNames(),
+		initials: make(map[string]string),
+		active:   make(map[*State]bool),
+	}
+	for _, opt := range opts {
+		opt(&g.opts)
+	}
+	states := make([]*State, 0, len(fsm.states))
+	for _, st := range fsm.states {
+		states = append(states, st)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].State < states[j].State })
+	for i, st := range states {
+		g.ids[st] = fmt.Sprintf("s%d", i)
+		g.byName[st.State] = st
+		if st.parent == nil {
+			g.roots[st.region] = append(g.roots[st.region], st)
+		} else {
+			g.children[st.parent] = append(g.children[st.parent], st)
+		}
+	}
+	for _, region := range g.regions {
+		g.initials[region] = fsm.initials[region]
+		for st := fsm.states[fsm.current(region)]; st != nil; st = st.parent {
+			g.active[st] = true
+		}
+	}
+
+	for _, etList := range fsm.events {
+		for elem := etList.Front(); elem != nil; elem = elem.Next() {
+			et := elem.Value.(*Event)
+			if g.ids[et.From] == "" || g.ids[et.To] == "" {
+				// dangling, the state was deleted or replaced
+				continue
+			}
+			g.edges = append(g.edges, graphEdge{et.From, et.To, et.Event, et.internal})
+		}
+	}
+	// FromAny events are drawn from every top level state of their region
+	// not overriding them
+	for event, ets := range fsm.anyEvents {
+		etList := fsm.events[event]
+		for _, et := range ets {
+			for _, st := range g.roots[et.To.region] {
+				if etList != nil && match(etList, st) != nil {
+					continue
+				}
+				g.edges = append(g.edges, graphEdge{st, et.To, et.Event, false})
+			}
+		}
+	}
+	sort.Slice(g.edges, func(i, j int) bool {
+		ei, ej := g.edges[i], g.edges[j]
+		if ei.from.State != ej.from.State {
+			return ei.from.State < ej.from.State
+		}
+		if ei.event != ej.event {
+			return ei.event < ej.event
+		}
+		return ei.to.State < ej.to.State
+	})
+	return g
+}
+
+// DOT renders the state graph in Graphviz DOT. Sub-states are drawn inside
+// their parent's cluster, named regions in a cluster of their own and
+// internal transitions dashed.
+func (fsm *FSM) DOT(opts ...GraphOption) string {
+	g := fsm.graph(opts)
+	b := &strings.Builder{}
+	b.WriteString("digraph fsm {\n")
+	b.WriteString("\tcompound=true;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+
+	var writeState func(st *State, indent string)
+	writeState = func(st *State, indent string) {
+		highlight := g.opts.current && g.active[st]
+		attrs := fmt.Sprintf("label=%q", st.State)
+		if highlight {
+			attrs += ", style=\"rounded,filled\", fillcolor=lightblue"
+		}
+		if len(g.children[st]) == 0 {
+			fmt.Fprintf(b, "%s%s [%s];\n", indent, g.ids[st], attrs)
+			return
+		}
+		fmt.Fprintf(b, "%ssubgraph cluster_%s {\n", indent, g.ids[st])
+		fmt.Fprintf(b, "%s\tlabel=%q;\n", indent, st.State)
+		if highlight {
+			fmt.Fprintf(b, "%s\tstyle=filled;\n%s\tfillcolor=aliceblue;\n", indent, indent)
+		}
+		fmt.Fprintf(b, "%s\t%s [%s];\n", indent, g.ids[st], attrs)
+		for _, child := range g.children[st] {
+			writeState(child, indent+"\t")
+		}
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+	for i, region := range g.regions {
+		indent := "\t"
+		if region != "" {
+			fmt.Fprintf(b, "\tsubgraph cluster_region%d {\n", i)
+			fmt.Fprintf(b, "\t\tlabel=%q;\n\t\tstyle=dashed;\n", region)
+			indent = "\t\t"
+		}
+		if _, ok := g.byName[g.initials[region]]; ok {
+			fmt.Fprintf(b, "%sstart%d [shape=point];\n", indent, i)
+		}
+		for _, st := range g.roots[region] {
+			writeState(st, indent)
+		}
+		if region != "" {
+			b.WriteString("\t}\n")
+		}
+	}
+	for i, region := range g.regions {
+		if st, ok := g.byName[g.initials[region]]; ok {
+			fmt.Fprintf(b, "\tstart%d -> %s;\n", i, g.ids[st])
+		}
+	}
+	for _, edge := range g.edges {
+		attrs := []string{}
+		if g.opts.labels {
+			attrs = append(attrs, fmt.Sprintf("label=%q", edge.event))
+		}
+		if edge.internal {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(b, "\t%s -> %s;\n", g.ids[edge.from], g.ids[edge.to])
+			continue
+		}
+		fmt.Fprintf(b, "\t%s -> %s [%s];\n", g.ids[edge.from], g.ids[edge.to], strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the state graph as a Mermaid stateDiagram-v2. Sub-states
+// are nested in their parent and internal transitions are labelled as such.
+func (fsm *FSM) Mermaid(opts ...GraphOption) string {
+	g := fsm.graph(opts)
+	b := &strings.Builder{}
+	b.WriteString("stateDiagram-v2\n")
+
+	// ids are declared with their names first, composite blocks then only
+	// refer to them
+	var writeState func(st *State, indent string)
+	writeState = func(st *State, indent string) {
+		fmt.Fprintf(b, "%sstate %q as %s\n", indent, st.State, g.ids[st])
+		for _, child := range g.children[st] {
+			writeState(child, indent)
+		}
+	}
+	var writeComposite func(st *State, indent string)
+	writeComposite = func(st *State, indent string) {
+		if len(g.children[st]) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "%sstate %s {\n", indent, g.ids[st])
+		for _, child := range g.children[st] {
+			fmt.Fprintf(b, "%s    %s\n", indent, g.ids[child])
+			writeComposite(child, indent+"    ")
+		}
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+	for _, region := range g.regions {
+		for _, st := range g.roots[region] {
+			writeState(st, "    ")
+		}
+	}
+	for _, region := range g.regions {
+		for _, st := range g.roots[region] {
+			writeComposite(st, "    ")
+		}
+	}
+	for _, region := range g.regions {
+		if st, ok := g.byName[g.initials[region]]; ok {
+			fmt.Fprintf(b, "    [*] --> %s\n", g.ids[st])
+		}
+	}
+	for _, edge := range g.edges {
+		label := ""
+		if g.opts.labels {
+			label = edge.event
+		}
+		if edge.internal {
+			label = strings.TrimSpace(label + " (internal)")
+		}
+		if label == "" {
+			fmt.Fprintf(b, "    %s --> %s\n", g.ids[edge.from], g.ids[edge.to])
+			continue
+		}
+		fmt.Fprintf(b, "    %s --> %s : %s\n", g.ids[edge.from], g.ids[edge.to], label)
+	}
+	if g.opts.current {
+		b.WriteString("    classDef current fill:#add8e6\n")
+		ids := []string{}
+		for st := range g.active {
+			ids = append(ids, g.ids[st])
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Fprintf(b, "    class %s current\n", id)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package yafsm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDOT(t *testing.T) {
+	fsm := newAB()
+	a := fsm.GetState(stateA)
+	fsm.AddInternalEvent("tick", a)
+
+	got := fsm.DOT()
+	want := `digraph fsm {
+	compound=true;
+	node [shape=box, style=rounded];
+	start0 [shape=point];
+	s0 [label="A"];
+	s1 [label="B"];
+	start0 -> s0;
+	s0 -> s1;
+	s0 -> s0 [style=dashed];
+}
+`
+	if got != want {
+		t.Fatalf("DOT =\n%s\nwant\n%s", got, want)
+	}
+
+	got = fsm.DOT(WithCurrentHighlighted(), WithEventLabels())
+	for _, part := range []string{
+		`s0 [label="A", style="rounded,filled", fillcolor=lightblue];`,
+		`s0 -> s1 [label="a->b"];`,
+		`s0 -> s0 [label="tick", style=dashed];`,
+	} {
+		if !strings.Contains(got, part) {
+			t.Fatalf("DOT missing %q:\n%s", part, got)
+		}
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	trace := []string{}
+	fsm := newConnFSM(t, &trace)
+	fsm.AddEventFromAny("reset", fsm.GetState("idle"))
+	fsm.EmitEvent("dial")
+
+	got := fsm.Mermaid(WithCurrentHighlighted(), WithEventLabels())
+	want := `stateDiagram-v2
+    state "connected" as s0
+    state "established" as s1
+    state "streaming" as s4
+    state "handshake" as s2
+    state "idle" as s3
+    state s0 {
+        s1
+        state s1 {
+            s4
+        }
+        s2
+    }
+    [*] --> s3
+    s0 --> s3 : error
+    s0 --> s3 : reset
+    s1 --> s2 : reset
+    s1 --> s4 : stream
+    s2 --> s1 : ready
+    s3 --> s2 : dial
+    s3 --> s3 : reset
+    classDef current fill:#add8e6
+    class s0 current
+    class s2 current
+`
+	if got != want {
+		t.Fatalf("Mermaid =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDOTRegions(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	got := fsm.DOT()
+	for _, part := range []string{
+		"subgraph cluster_region1 {",
+		`label="auth";`,
+		"start1 -> s0;",
+	} {
+		if !strings.Contains(got, part) {
+			t.Fatalf("DOT missing %q:\n%s", part, got)
+		}
+	}
+}
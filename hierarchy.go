@@ -0,0 +1,67 @@
+package yafsm
+
+// AddSubState adds state as a child of parent, in parent's region, an
+// existing state is re-parented. Events defined on parent apply to all of its descendants
+// unless a descendant defines the same event itself.
+func (fsm *FSM) AddSubState(parent *State, state string) (*State, error) {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if fsm.states[parent.State] != parent {
+		return nil, ErrStateNotExist
+	}
+	st, ok := fsm.states[state]
+	if ok && st.region != parent.region {
+		return nil, ErrRegionMismatch
+	}
+	if !ok {
+		st = &State{State: state, region: parent.region}
+		fsm.states[state] = st
+	}
+	if parent.within(st) {
+		return nil, ErrStateCycle
+	}
+	st.parent = parent
+	return st, nil
+}
+
+func (st *State) Parent() *State {
+	return st.parent
+}
+
+// within reports whether st is ancestor or st itself.
+func (st *State) within(ancestor *State) bool {
+	for s := st; s != nil; s = s.parent {
+		if s == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// path returns the states to leave, innermost first, and to enter, outermost
+// first, when moving from the leaf from to to. The transition is external:
+// moving to an ancestor or descendant (or to itself) leaves and re-enters
+// the outer state.
+func path(from, to *State) (exits, enters []*State) {
+	lca := (*State)(nil)
+	for s := from; s != nil; s = s.parent {
+		if to.within(s) {
+			lca = s
+			break
+		}
+	}
+	if lca == from || lca == to {
+		lca = lca.parent
+	}
+	for s := from; s != lca; s = s.parent {
+		exits = append(exits, s)
+	}
+	for s := to; s != lca; s = s.parent {
+		enters = append(enters, s)
+	}
+	for i, j := 0, len(enters)-1; i < j; i, j = i+1, j-1 {
+		enters[i], enters[j] = enters[j], enters[i]
+	}
+	return exits, enters
+}
@@ -0,0 +1,130 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newConnFSM builds:
+//
+//	idle
+//	connected
+//	  ├── handshake
+//	  └── established
+//	        └── streaming
+func newConnFSM(t *testing.T, trace *[]string) *FSM {
+	fsm := NewFSM()
+	idle := fsm.Init("idle")
+	connected := fsm.AddState("connected")
+	handshake, err := fsm.AddSubState(connected, "handshake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	established, _ := fsm.AddSubState(connected, "established")
+	streaming, _ := fsm.AddSubState(established, "streaming")
+
+	for _, st := range []*State{idle, connected, handshake, established, streaming} {
+		st.AddEnter(func(st *State) { *trace = append(*trace, "enter "+st.State) })
+		st.AddLeft(func(st *State) { *trace = append(*trace, "leave "+st.State) })
+	}
+	fsm.AddEvent("dial", idle, handshake)
+	fsm.AddEvent("ready", handshake, established)
+	fsm.AddEvent("stream", established, streaming)
+	fsm.AddEvent("error", connected, idle)
+	fsm.AddEvent("reset", established, handshake)
+	return fsm
+}
+
+func TestHierarchyInheritedEvent(t *testing.T) {
+	trace := []string{}
+	fsm := newConnFSM(t, &trace)
+	for _, ev := range []string{"dial", "ready", "stream"} {
+		if err := fsm.EmitEvent(ev); err != nil {
+			t.Fatalf("%s: %v", ev, err)
+		}
+	}
+	if !fsm.InStates("connected") || !fsm.InStates("established") || fsm.InStates("handshake") {
+		t.Fatal("InStates should report ancestors of the current leaf")
+	}
+
+	trace = trace[:0]
+	// error is only defined on connected
+	if err := fsm.EmitEvent("error"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"leave streaming", "leave established", "leave connected", "enter idle"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+	if fsm.State() != "idle" {
+		t.Fatalf("expected idle, got %q", fsm.State())
+	}
+}
+
+func TestHierarchyLCAOrder(t *testing.T) {
+	trace := []string{}
+	fsm := newConnFSM(t, &trace)
+	if err := fsm.EmitEvent("dial"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"leave idle", "enter connected", "enter handshake"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+
+	fsm.EmitEvent("ready")
+	fsm.EmitEvent("stream")
+	trace = trace[:0]
+	// reset from streaming, inherited from established, stays inside connected
+	if err := fsm.EmitEvent("reset"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"leave streaming", "leave established", "enter handshake"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+}
+
+func TestHierarchyRollback(t *testing.T) {
+	fsm := NewFSM(WithFailurePolicy(FailureRollback))
+	idle := fsm.Init("idle")
+	connected := fsm.AddState("connected")
+	handshake, _ := fsm.AddSubState(connected, "handshake")
+	fsm.AddEvent("dial", idle, handshake)
+
+	left := false
+	connected.AddLeft(func(*State) { left = true })
+	boom := errors.New("boom")
+	handshake.AddEnterE(func(_ context.Context, st *State) error { return boom })
+	if err := fsm.EmitEvent("dial"); !errors.Is(err, boom) {
+		t.Fatalf("want boom, got %v", err)
+	}
+	if fsm.State() != "idle" || !left {
+		t.Fatalf("state=%q, connected left=%v", fsm.State(), left)
+	}
+}
+
+func TestAddSubStateErrors(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	b, err := fsm.AddSubState(a, stateB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Parent() != a {
+		t.Fatal("parent not set")
+	}
+	if _, err := fsm.AddSubState(b, stateA); !errors.Is(err, ErrStateCycle) {
+		t.Fatalf("want ErrStateCycle, got %v", err)
+	}
+	if _, err := fsm.AddSubState(NewState("ghost"), stateC); !errors.Is(err, ErrStateNotExist) {
+		t.Fatalf("want ErrStateNotExist, got %v", err)
+	}
+	// deleting a parent re-parents its children
+	fsm.DelState(stateA)
+	if b.Parent() != nil {
+		t.Fatal("child should have been re-parented to the root")
+	}
+}
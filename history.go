@@ -0,0 +1,140 @@
+package yafsm
+
+import (
+	"sync"
+	"time"
+)
+
+// Record is one attempted transition. A rejected emission has an empty To
+// and From set to the state of the default region at that time, an emission
+// moving several regions leaves one record per region.
+type Record struct {
+	Event    string
+	Region   string
+	From, To string
+	Prio     int
+	Time     time.Time
+	Err      error
+}
+
+// WithHistory keeps the last size attempted transitions, see History.
+func WithHistory(size int) FSMOption {
+	return func(fsm *FSM) {
+		if size > 0 {
+			fsm.history = &history{records: make([]Record, size)}
+		}
+	}
+}
+
+// history is a ring buffer of records.
+type history struct {
+	mutex   sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+func (h *history) add(records ...Record) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, record := range records {
+		h.records[h.next] = record
+		h.next++
+		if h.next == len(h.records) {
+			h.next = 0
+			h.full = true
+		}
+	}
+}
+
+// reset replaces the records, keeping the newest if they don't all fit.
+func (h *history) reset(records []Record) {
+	h.mutex.Lock()
+	for i := range h.records {
+		h.records[i] = Record{}
+	}
+	h.next, h.full = 0, false
+	h.mutex.Unlock()
+
+	if len(records) > len(h.records) {
+		records = records[len(records)-len(h.records):]
+	}
+	h.add(records...)
+}
+
+// filter returns the matching records, oldest first.
+func (h *history) filter(match func(*Record) bool) []Record {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	records := []Record{}
+	start, n := 0, h.next
+	if h.full {
+		start, n = h.next, len(h.records)
+	}
+	for i := 0; i < n; i++ {
+		record := &h.records[(start+i)%len(h.records)]
+		if match == nil || match(record) {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+func (fsm *FSM) record(ec *eventchan, now time.Time, from string, trs []*Transition, err error) {
+	if fsm.history == nil {
+		return
+	}
+	if len(trs) == 0 {
+		fsm.history.add(Record{
+			Event: ec.event,
+			From:  from,
+			Prio:  ec.prio,
+			Time:  now,
+			Err:   err,
+		})
+		return
+	}
+	records := make([]Record, 0, len(trs))
+	for _, tr := range trs {
+		records = append(records, Record{
+			Event:  tr.Event,
+			Region: tr.Region,
+			From:   tr.From,
+			To:     tr.To,
+			Prio:   ec.prio,
+			Time:   now,
+			Err:    err,
+		})
+	}
+	fsm.history.add(records...)
+}
+
+// History returns the recorded transitions, oldest first, or nil if
+// WithHistory wasn't given.
+func (fsm *FSM) History() []Record {
+	if fsm.history == nil {
+		return nil
+	}
+	return fsm.history.filter(nil)
+}
+
+func (fsm *FSM) HistoryByEvent(event string) []Record {
+	if fsm.history == nil {
+		return nil
+	}
+	return fsm.history.filter(func(record *Record) bool {
+		return record.Event == event
+	})
+}
+
+// HistoryByState returns the records leaving or entering state.
+func (fsm *FSM) HistoryByState(state string) []Record {
+	if fsm.history == nil {
+		return nil
+	}
+	return fsm.history.filter(func(record *Record) bool {
+		return record.From == state || record.To == state
+	})
+}
@@ -0,0 +1,89 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHistoryRecords(t *testing.T) {
+	fsm := NewFSM(WithHistory(8))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	c := fsm.AddState(stateC)
+	fsm.AddEvent(evAB, a, b)
+	bc, _ := fsm.AddEvent(evBC, b, c)
+	bc.AddGuard(func(ctx context.Context, et *Event) error { return errors.New("no") })
+
+	if err := fsm.EmitPrioEvent(3, evAB); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent(evBC); !errors.Is(err, ErrGuardRejected) {
+		t.Fatalf("want ErrGuardRejected, got %v", err)
+	}
+	if err := fsm.EmitEvent("missing"); !errors.Is(err, ErrEventNotExist) {
+		t.Fatalf("want ErrEventNotExist, got %v", err)
+	}
+
+	records := fsm.History()
+	if len(records) != 3 {
+		t.Fatalf("want 3 records, got %v", records)
+	}
+	if r := records[0]; r.Event != evAB || r.From != stateA || r.To != stateB || r.Prio != 3 || r.Err != nil || r.Time.IsZero() {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r := records[1]; r.Event != evBC || r.From != stateB || r.To != "" || !errors.Is(r.Err, ErrGuardRejected) {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r := records[2]; r.Event != "missing" || !errors.Is(r.Err, ErrEventNotExist) {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	if records := fsm.HistoryByEvent(evBC); len(records) != 1 || records[0].Event != evBC {
+		t.Fatalf("by event: %v", records)
+	}
+	if records := fsm.HistoryByState(stateB); len(records) != 3 {
+		t.Fatalf("by state: %v", records)
+	}
+	if records := fsm.HistoryByState(stateC); len(records) != 0 {
+		t.Fatalf("by state: %v", records)
+	}
+}
+
+func TestHistoryBounded(t *testing.T) {
+	fsm := NewFSM(WithHistory(3))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	fsm.AddEvent(evAB, a, b)
+	fsm.AddEvent("b->a", b, a)
+
+	for i := 0; i < 5; i++ {
+		event := evAB
+		if i%2 == 1 {
+			event = "b->a"
+		}
+		if err := fsm.EmitEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records := fsm.History()
+	if len(records) != 3 {
+		t.Fatalf("want 3 records, got %d", len(records))
+	}
+	// the 3rd to 5th emissions, oldest first
+	for i, want := range []string{evAB, "b->a", evAB} {
+		if records[i].Event != want {
+			t.Fatalf("record %d: want %s, got %s", i, want, records[i].Event)
+		}
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	fsm.AddEvent(evAB, a, fsm.AddState(stateB))
+	fsm.EmitEvent(evAB)
+	if fsm.History() != nil || fsm.HistoryByEvent(evAB) != nil || fsm.HistoryByState(stateA) != nil {
+		t.Fatal("history should be nil when disabled")
+	}
+}
@@ -0,0 +1,23 @@
+package yafsm
+
+// AddInternalEvent registers an internal transition of st: only the event's
+// guards and handlers run, st's enter and leave hooks are not triggered and
+// the FSM stays where it is. AddEvent(event, st, st) keeps the external
+// semantics of leaving and re-entering st.
+func (fsm *FSM) AddInternalEvent(event string, st *State,
+	handlers ...EventHandler) (*Event, error) {
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if err := fsm.checkEvent(event, st, st); err != nil {
+		return nil, err
+	}
+	et := fsm.addEvent(event, st, st, handlers)
+	et.internal = true
+	return et, nil
+}
+
+func (et *Event) Internal() bool {
+	return et.internal
+}
@@ -0,0 +1,53 @@
+package yafsm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInternalEventSkipsStateHooks(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	trace := []string{}
+	a.AddEnter(func(*State) { trace = append(trace, "enter") })
+	a.AddLeft(func(*State) { trace = append(trace, "leave") })
+
+	tick, err := fsm.AddInternalEvent("tick", a, func(*Event) { trace = append(trace, "tick") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tick.Internal() {
+		t.Fatal("Internal should be true")
+	}
+	if _, err := fsm.AddEvent("restart", a, a, func(*Event) { trace = append(trace, "restart") }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fsm.EmitEvent("tick"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent("restart"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"tick", "leave", "restart", "enter"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+}
+
+func TestInternalEventInheritedStaysInLeaf(t *testing.T) {
+	trace := []string{}
+	fsm := newConnFSM(t, &trace)
+	connected := fsm.GetState("connected")
+	if _, err := fsm.AddInternalEvent("keepalive", connected); err != nil {
+		t.Fatal(err)
+	}
+	fsm.EmitEvent("dial")
+	trace = trace[:0]
+	if err := fsm.EmitEvent("keepalive"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.State() != "handshake" || len(trace) != 0 {
+		t.Fatalf("state=%q trace=%v", fsm.State(), trace)
+	}
+}
@@ -0,0 +1,139 @@
+package yafsm
+
+import (
+	"context"
+	"time"
+)
+
+// Emission describes an emitted event to listeners.
+type Emission struct {
+	Event string
+	Prio  int
+	Args  []interface{}
+	// Mode is "sync", "inseq", "async" or, for Replay, "replay".
+	Mode string
+	// Queued is when the event was emitted, Started when its handling began
+	// and Ended when it was over, before AfterEvent.
+	Queued, Started, Ended time.Time
+	// Transitions are set once the event matched the current states.
+	Transitions []*Transition
+}
+
+// Step is the run of the leave or enter handlers of one state, or of the
+// handlers of one event, Name being the state or the event.
+type Step struct {
+	Transition *Transition
+	Phase      Phase
+	Name       string
+	Start      time.Time
+	Duration   time.Duration
+	Err        error
+}
+
+// Listener observes every emission of an FSM. An emission handled is framed
+// by BeforeEvent and AfterEvent, between which it's either Rejected or goes
+// through Leave, Transition and Enter for every state left, region moved and
+// state entered. An emission rejected before being queued, e.g. with
+// ErrEventNotExist, only gets Rejected. Listeners run on the goroutine
+// handling the emission, while the FSM is locked in WithInSeq mode.
+type Listener interface {
+	BeforeEvent(ctx context.Context, em *Emission)
+	Rejected(ctx context.Context, em *Emission, err error)
+	Leave(ctx context.Context, em *Emission, step *Step)
+	Transition(ctx context.Context, em *Emission, step *Step)
+	Enter(ctx context.Context, em *Emission, step *Step)
+	AfterEvent(ctx context.Context, em *Emission, err error)
+}
+
+// NopListener implements Listener doing nothing, embed it to implement only
+// the callbacks needed.
+type NopListener struct{}
+
+func (NopListener) BeforeEvent(context.Context, *Emission)       {}
+func (NopListener) Rejected(context.Context, *Emission, error)   {}
+func (NopListener) Leave(context.Context, *Emission, *Step)      {}
+func (NopListener) Transition(context.Context, *Emission, *Step) {}
+func (NopListener) Enter(context.Context, *Emission, *Step)      {}
+func (NopListener) AfterEvent(context.Context, *Emission, error) {}
+
+// AddListener adds listener, emissions already queued aren't told about.
+func (fsm *FSM) AddListener(listener Listener) {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	// copied on write, emissions keep the listeners they were queued with
+	listeners := make([]Listener, len(fsm.listeners), len(fsm.listeners)+1)
+	copy(listeners, fsm.listeners)
+	fsm.listeners = append(listeners, listener)
+}
+
+func (fsm *FSM) mode() string {
+	switch {
+	case fsm.async:
+		return "async"
+	case fsm.inseq:
+		return "inseq"
+	}
+	return "sync"
+}
+
+func (ec *eventchan) before() {
+	for _, listener := range ec.listeners {
+		listener.BeforeEvent(ec.ctx, ec.em)
+	}
+}
+
+func (ec *eventchan) rejected(err error) {
+	for _, listener := range ec.listeners {
+		listener.Rejected(ec.ctx, ec.em, err)
+	}
+}
+
+func (ec *eventchan) after(end time.Time, err error) {
+	ec.em.Ended = end
+	for _, listener := range ec.listeners {
+		listener.AfterEvent(ec.ctx, ec.em, err)
+	}
+}
+
+// stepFunc reports the steps of a transition, a nil one does nothing.
+type stepFunc func(step *Step)
+
+func (ec *eventchan) stepFunc() stepFunc {
+	if len(ec.listeners) == 0 {
+		return nil
+	}
+	return func(step *Step) {
+		for _, listener := range ec.listeners {
+			switch step.Phase {
+			case PhaseLeave:
+				listener.Leave(step.Transition.ctx, ec.em, step)
+			case PhaseEvent:
+				listener.Transition(step.Transition.ctx, ec.em, step)
+			case PhaseEnter:
+				listener.Enter(step.Transition.ctx, ec.em, step)
+			}
+		}
+	}
+}
+
+func (step stepFunc) done(tr *Transition, phase Phase, name string, start time.Time, err error) {
+	if step == nil {
+		return
+	}
+	step(&Step{
+		Transition: tr,
+		Phase:      phase,
+		Name:       name,
+		Start:      start,
+		Duration:   tr.clock.Now().Sub(start),
+		Err:        err,
+	})
+}
+
+// now is only taken if there is someone to tell.
+func (tr *Transition) now(step stepFunc) time.Time {
+	if step == nil {
+		return time.Time{}
+	}
+	return tr.clock.Now()
+}
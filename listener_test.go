@@ -0,0 +1,120 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type traceListener struct {
+	NopListener
+	mutex sync.Mutex
+	trace []string
+}
+
+func (l *traceListener) add(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.trace = append(l.trace, fmt.Sprintf(format, args...))
+}
+
+func (l *traceListener) BeforeEvent(ctx context.Context, em *Emission) {
+	l.add("before %s", em.Event)
+}
+
+func (l *traceListener) Rejected(ctx context.Context, em *Emission, err error) {
+	l.add("rejected %s: %v", em.Event, err)
+}
+
+func (l *traceListener) Leave(ctx context.Context, em *Emission, step *Step) {
+	l.add("leave %s", step.Name)
+}
+
+func (l *traceListener) Transition(ctx context.Context, em *Emission, step *Step) {
+	l.add("transition %s %s->%s", step.Name, step.Transition.From, step.Transition.To)
+}
+
+func (l *traceListener) Enter(ctx context.Context, em *Emission, step *Step) {
+	l.add("enter %s err=%v", step.Name, step.Err != nil)
+}
+
+func (l *traceListener) AfterEvent(ctx context.Context, em *Emission, err error) {
+	l.add("after %s: %v", em.Event, err)
+}
+
+func TestListener(t *testing.T) {
+	for name, opts := range map[string][]FSMOption{
+		"sync":  nil,
+		"inseq": {WithInSeq()},
+		"async": {WithAsync()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsm := newLinkAuthFSM(t, opts...)
+			defer fsm.Close()
+			listener := &traceListener{}
+			fsm.AddListener(listener)
+			errAuth := errors.New("no")
+			fsm.GetState("authenticated").AddEnterE(func(context.Context, *State) error { return errAuth })
+
+			fsm.EmitEvent("connect")
+			fsm.EmitEvent("connect")
+			fsm.EmitEvent("login")
+			fsm.EmitEvent("missing")
+
+			want := []string{
+				"before connect",
+				"leave down",
+				"transition connect down->up",
+				"enter up err=false",
+				"after connect: <nil>",
+				"before connect",
+				"rejected connect: " + ErrIllegalStateForEvent.Error(),
+				"after connect: " + ErrIllegalStateForEvent.Error(),
+				"before login",
+				"leave anonymous",
+				"transition login anonymous->authenticated",
+				"enter authenticated err=true",
+				`after login: enter handler #0 of "authenticated": no`,
+				"rejected missing: " + ErrEventNotExist.Error(),
+			}
+			if !reflect.DeepEqual(listener.trace, want) {
+				t.Fatalf("unexpected trace\n%q\nwant\n%q", listener.trace, want)
+			}
+		})
+	}
+}
+
+func TestListenerEmission(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	var got *Emission
+	var steps []*Step
+	// a listener only embedding NopListener does nothing
+	fsm.AddListener(NopListener{})
+	fsm.AddListener(listenerFuncs{
+		after: func(em *Emission) { got = em },
+		step:  func(step *Step) { steps = append(steps, step) },
+	})
+	fsm.EmitPrioEventWithArgs(3, "connect", "pkt")
+	if got == nil || got.Event != "connect" || got.Prio != 3 || !reflect.DeepEqual(got.Args, []interface{}{"pkt"}) ||
+		got.Queued.IsZero() || got.Started.Before(got.Queued) || len(got.Transitions) != 1 {
+		t.Fatalf("unexpected emission %+v", got)
+	}
+	if len(steps) != 3 || steps[0].Phase != PhaseLeave || steps[1].Phase != PhaseEvent || steps[2].Phase != PhaseEnter ||
+		steps[2].Start.IsZero() || steps[2].Duration < 0 {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+}
+
+type listenerFuncs struct {
+	NopListener
+	after func(em *Emission)
+	step  func(step *Step)
+}
+
+func (l listenerFuncs) AfterEvent(ctx context.Context, em *Emission, err error)  { l.after(em) }
+func (l listenerFuncs) Leave(ctx context.Context, em *Emission, step *Step)      { l.step(step) }
+func (l listenerFuncs) Transition(ctx context.Context, em *Emission, step *Step) { l.step(step) }
+func (l listenerFuncs) Enter(ctx context.Context, em *Emission, step *Step)      { l.step(step) }
@@ -0,0 +1,115 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/singchia/yafsm/pkg/prioqueue"
+)
+
+// LogCategory groups what an FSM set up WithLogger logs.
+type LogCategory int
+
+const (
+	// LogQueueFull is an event dropped because the queue is full, logged at
+	// slog.LevelWarn by default.
+	LogQueueFull LogCategory = iota
+	// LogRejected is any other rejected emission, e.g. ErrIllegalStateForEvent,
+	// logged at slog.LevelInfo by default.
+	LogRejected
+	// LogPanic is a panicking handler, logged at slog.LevelError by default.
+	LogPanic
+	// LogClose is the FSM being closed, logged at slog.LevelInfo by default.
+	LogClose
+	logCategories
+)
+
+// LevelOff disables a category when given to WithLogLevel.
+const LevelOff = slog.Level(math.MaxInt32)
+
+var fsmIDs uint64
+
+// WithID names the FSM in logs, by default it's a number unique to the
+// process.
+func WithID(id string) FSMOption {
+	return func(fsm *FSM) {
+		fsm.id = id
+	}
+}
+
+// WithLogger logs rejected emissions, panicking handlers and Close to logger,
+// with the attributes fsm, event, from, to and prio where they apply.
+func WithLogger(logger *slog.Logger) FSMOption {
+	return func(fsm *FSM) {
+		fsm.logger = logger
+	}
+}
+
+func WithLogLevel(category LogCategory, level slog.Level) FSMOption {
+	return func(fsm *FSM) {
+		if category >= 0 && category < logCategories {
+			fsm.logLevels[category] = level
+		}
+	}
+}
+
+var defaultLogLevels = [logCategories]slog.Level{
+	LogQueueFull: slog.LevelWarn,
+	LogRejected:  slog.LevelInfo,
+	LogPanic:     slog.LevelError,
+	LogClose:     slog.LevelInfo,
+}
+
+func newFSMID() string {
+	return strconv.FormatUint(atomic.AddUint64(&fsmIDs, 1), 10)
+}
+
+func (fsm *FSM) log(ctx context.Context, category LogCategory, msg string, attrs ...slog.Attr) {
+	if fsm.logger == nil {
+		return
+	}
+	level := fsm.logLevels[category]
+	if level == LevelOff || !fsm.logger.Enabled(ctx, level) {
+		return
+	}
+	fsm.logger.LogAttrs(ctx, level, msg, append([]slog.Attr{slog.String("fsm", fsm.id)}, attrs...)...)
+}
+
+// rejected reports an emission that went nowhere, from is the state of the
+// default region.
+func (fsm *FSM) rejected(ec *eventchan, now time.Time, from string, err error) {
+	fsm.record(ec, now, from, nil, err)
+	ec.rejected(err)
+	if fsm.logger == nil {
+		return
+	}
+	category, msg := LogRejected, "event rejected"
+	if errors.Is(err, prioqueue.ErrQueueFull) {
+		category, msg = LogQueueFull, "event dropped"
+	}
+	fsm.log(ec.ctx, category, msg,
+		slog.String("event", ec.event),
+		slog.String("from", from),
+		slog.Int("prio", ec.prio),
+		slog.Any("err", err))
+}
+
+// logPanic logs a panic recovered while handling ec.
+func (fsm *FSM) logPanic(ec *eventchan, trs []*Transition, pe *PanicError) {
+	attrs := []slog.Attr{
+		slog.String("event", ec.event),
+		slog.Int("prio", ec.prio),
+		slog.String("panic", fmt.Sprint(pe.Value)),
+		slog.String("stack", string(pe.Stack)),
+	}
+	if len(trs) != 0 {
+		attrs = append(attrs, slog.String("from", trs[0].From), slog.String("to", trs[0].To))
+	}
+	fsm.log(ec.ctx, LogPanic, "handler panicked", attrs...)
+}
@@ -0,0 +1,105 @@
+package yafsm
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/singchia/yafsm/pkg/prioqueue"
+)
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func newTestLogger(buf *syncBuffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey || attr.Key == "stack" {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	}))
+}
+
+func TestLogRejected(t *testing.T) {
+	buf := &syncBuffer{}
+	fsm := newLinkAuthFSM(t, WithLogger(newTestLogger(buf)), WithID("link0"),
+		WithLogLevel(LogRejected, slog.LevelWarn))
+	fsm.EmitEvent("connect")
+	fsm.EmitPrioEvent(3, "connect")
+	fsm.EmitEvent("missing")
+	fsm.Close()
+
+	want := `level=WARN msg="event rejected" fsm=link0 event=connect from=up prio=3 err="illegal state for event"
+level=WARN msg="event rejected" fsm=link0 event=missing from=up prio=1 err="event does not exist"
+level=INFO msg="fsm closed" fsm=link0 state=up
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected log\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLogQueueFull(t *testing.T) {
+	buf := &syncBuffer{}
+	fsm := newLinkAuthFSM(t, WithAsync(), WithLogger(newTestLogger(buf)), WithLogLevel(LogClose, LevelOff))
+	defer fsm.Close()
+	entered, release := make(chan struct{}), make(chan struct{})
+	fsm.GetState("up").AddEnter(func(*State) {
+		close(entered)
+		<-release
+	})
+	connected := fsm.EmitEventAsync("connect")
+	<-entered
+	for i := 0; i < fsm.QueueStats().Capacity; i++ {
+		fsm.EmitEventAsync("login")
+	}
+	if err := <-fsm.EmitEventAsync("login"); !errors.Is(err, prioqueue.ErrQueueFull) {
+		t.Fatalf("want ErrQueueFull, got %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, `level=WARN msg="event dropped" fsm=`) ||
+		!strings.Contains(got, `event=login from=up prio=1 err="queue full"`) {
+		t.Fatalf("unexpected log %s", got)
+	}
+	close(release)
+	<-connected
+}
+
+func TestLogPanic(t *testing.T) {
+	buf := &syncBuffer{}
+	fsm := newLinkAuthFSM(t, WithLogger(newTestLogger(buf)))
+	fsm.GetState("up").AddEnter(func(*State) { panic("boom") })
+
+	pe := (*PanicError)(nil)
+	if err := fsm.EmitEvent("connect"); !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("want PanicError, got %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, `level=ERROR msg="handler panicked"`) ||
+		!strings.Contains(got, "event=connect prio=1 panic=boom from=down to=up") {
+		t.Fatalf("unexpected log %s", got)
+	}
+}
+
+func TestFSMIDs(t *testing.T) {
+	if NewFSM().id == NewFSM().id {
+		t.Fatal("ids should be unique")
+	}
+}
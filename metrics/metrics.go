@@ -0,0 +1,312 @@
+// Package metrics collects yafsm transitions, rejections, handler latencies
+// and queue depths, and serves them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/singchia/yafsm"
+	"github.com/singchia/yafsm/pkg/prioqueue"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the handler latency
+// histogram.
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+type Option func(*Collector)
+
+// WithNamespace prefixes the metric names, "yafsm" by default.
+func WithNamespace(namespace string) Option {
+	return func(c *Collector) {
+		c.namespace = namespace
+	}
+}
+
+func WithBuckets(buckets ...float64) Option {
+	return func(c *Collector) {
+		c.buckets = append([]float64(nil), buckets...)
+		sort.Float64s(c.buckets)
+	}
+}
+
+type transitionKey struct {
+	event, from, to, result string
+}
+
+type rejectionKey struct {
+	event, reason string
+}
+
+type stepKey struct {
+	phase yafsm.Phase
+	name  string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Collector is a yafsm.Listener aggregating over every FSM registered.
+type Collector struct {
+	yafsm.NopListener
+
+	namespace string
+	buckets   []float64
+
+	mutex       sync.Mutex
+	fsms        map[*yafsm.FSM]struct{}
+	transitions map[transitionKey]uint64
+	rejections  map[rejectionKey]uint64
+	steps       map[stepKey]*histogram
+}
+
+func New(opts ...Option) *Collector {
+	c := &Collector{
+		namespace:   "yafsm",
+		buckets:     DefaultBuckets,
+		fsms:        make(map[*yafsm.FSM]struct{}),
+		transitions: make(map[transitionKey]uint64),
+		rejections:  make(map[rejectionKey]uint64),
+		steps:       make(map[stepKey]*histogram),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Register adds c as listener of fsm and includes its queue in the gauges,
+// until Unregister.
+func (c *Collector) Register(fsm *yafsm.FSM) {
+	fsm.AddListener(c)
+	c.mutex.Lock()
+	c.fsms[fsm] = struct{}{}
+	c.mutex.Unlock()
+}
+
+// Unregister drops the queue of fsm from the gauges, e.g. once closed.
+// Its emissions are still counted.
+func (c *Collector) Unregister(fsm *yafsm.FSM) {
+	c.mutex.Lock()
+	delete(c.fsms, fsm)
+	c.mutex.Unlock()
+}
+
+func (c *Collector) Rejected(ctx context.Context, em *yafsm.Emission, err error) {
+	c.mutex.Lock()
+	c.rejections[rejectionKey{em.Event, reason(err)}]++
+	c.mutex.Unlock()
+}
+
+func (c *Collector) Leave(ctx context.Context, em *yafsm.Emission, step *yafsm.Step) {
+	c.observe(step)
+}
+
+func (c *Collector) Transition(ctx context.Context, em *yafsm.Emission, step *yafsm.Step) {
+	c.observe(step)
+}
+
+func (c *Collector) Enter(ctx context.Context, em *yafsm.Emission, step *yafsm.Step) {
+	c.observe(step)
+}
+
+func (c *Collector) AfterEvent(ctx context.Context, em *yafsm.Emission, err error) {
+	if len(em.Transitions) == 0 {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	c.mutex.Lock()
+	for _, tr := range em.Transitions {
+		c.transitions[transitionKey{tr.Event, tr.From, tr.To, result}]++
+	}
+	c.mutex.Unlock()
+}
+
+func (c *Collector) observe(step *yafsm.Step) {
+	seconds := step.Duration.Seconds()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := stepKey{step.Phase, step.Name}
+	h, ok := c.steps[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		c.steps[key] = h
+	}
+	for i, bound := range c.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// reason labels the rejections by cause.
+func reason(err error) string {
+	switch {
+	case errors.Is(err, yafsm.ErrEventNotExist):
+		return "event_not_exist"
+	case errors.Is(err, yafsm.ErrIllegalStateForEvent):
+		return "illegal_state"
+	case errors.Is(err, yafsm.ErrGuardRejected):
+		return "guard_rejected"
+	case errors.Is(err, yafsm.ErrFSMDone):
+		return "fsm_done"
+	case errors.Is(err, prioqueue.ErrQueueFull):
+		return "queue_full"
+	case errors.Is(err, yafsm.ErrFSMClosed), errors.Is(err, prioqueue.ErrQueueClosed):
+		return "closed"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	}
+	return "other"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	c.write(cw)
+	if err := cw.w.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func (c *Collector) write(w *countWriter) {
+	c.mutex.Lock()
+	fsms := make([]*yafsm.FSM, 0, len(c.fsms))
+	for fsm := range c.fsms {
+		fsms = append(fsms, fsm)
+	}
+	transitions := make([]string, 0, len(c.transitions))
+	for key, count := range c.transitions {
+		transitions = append(transitions, fmt.Sprintf("%s %d",
+			labels("event", key.event, "from", key.from, "to", key.to, "result", key.result), count))
+	}
+	rejections := make([]string, 0, len(c.rejections))
+	for key, count := range c.rejections {
+		rejections = append(rejections, fmt.Sprintf("%s %d",
+			labels("event", key.event, "reason", key.reason), count))
+	}
+	keys := make([]stepKey, 0, len(c.steps))
+	for key := range c.steps {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].phase != keys[j].phase {
+			return keys[i].phase < keys[j].phase
+		}
+		return keys[i].name < keys[j].name
+	})
+	// histogram lines are kept in bucket order
+	steps := []string{}
+	for _, key := range keys {
+		h := c.steps[key]
+		phase := string(key.phase)
+		cumulative := uint64(0)
+		for i, bound := range c.buckets {
+			cumulative += h.counts[i]
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			steps = append(steps, fmt.Sprintf("_bucket%s %d", labels("phase", phase, "name", key.name, "le", le), cumulative))
+		}
+		l := labels("phase", phase, "name", key.name)
+		steps = append(steps,
+			fmt.Sprintf("_bucket%s %d", labels("phase", phase, "name", key.name, "le", "+Inf"), h.count),
+			fmt.Sprintf("_sum%s %s", l, strconv.FormatFloat(h.sum, 'g', -1, 64)),
+			fmt.Sprintf("_count%s %d", l, h.count))
+	}
+	c.mutex.Unlock()
+
+	// the queues are read outside of c.mutex, listeners may be running
+	depths, available := map[int]int{}, 0
+	for _, fsm := range fsms {
+		stats := fsm.QueueStats()
+		for prio, depth := range stats.Depth {
+			depths[prio] += depth
+		}
+		available += stats.Available
+	}
+	queue := make([]string, 0, len(depths))
+	for prio, depth := range depths {
+		queue = append(queue, fmt.Sprintf("%s %d", labels("prio", strconv.Itoa(prio)), depth))
+	}
+
+	ns := c.namespace
+	w.family(ns+"_transitions_total", "counter", "Transitions by event, source and target state and result.", transitions)
+	w.family(ns+"_rejections_total", "counter", "Rejected emissions by event and reason.", rejections)
+	w.printf("# HELP %s_handler_duration_seconds Duration of the handlers of a state or event.\n", ns)
+	w.printf("# TYPE %s_handler_duration_seconds histogram\n", ns)
+	for _, line := range steps {
+		w.printf("%s_handler_duration_seconds%s\n", ns, line)
+	}
+	w.family(ns+"_queue_depth", "gauge", "Queued events by priority.", queue)
+	w.family(ns+"_queue_available", "gauge", "Free slots in the queues.", []string{strconv.Itoa(available)})
+	w.family(ns+"_fsms", "gauge", "Registered FSMs.", []string{strconv.Itoa(len(fsms))})
+}
+
+// labels formats name value pairs, escaped, as {name="value",...}.
+func labels(pairs ...string) string {
+	b := &strings.Builder{}
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, "%s=\"%s\"", pairs[i], escaper.Replace(pairs[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+// family writes a metric with its samples, each being labels and value or
+// only a value, sorted.
+func (w *countWriter) family(name, typ, help string, samples []string) {
+	sort.Strings(samples)
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, sample := range samples {
+		if strings.HasPrefix(sample, "{") {
+			w.printf("%s%s\n", name, sample)
+		} else {
+			w.printf("%s %s\n", name, sample)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/singchia/yafsm"
+)
+
+func newFSM(t *testing.T, opts ...yafsm.FSMOption) *yafsm.FSM {
+	fsm := yafsm.NewFSM(opts...)
+	idle := fsm.Init("idle")
+	running := fsm.AddState("running")
+	if _, err := fsm.AddEvent("start", idle, running); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsm.AddEvent("stop", running, idle); err != nil {
+		t.Fatal(err)
+	}
+	return fsm
+}
+
+func scrape(t *testing.T, c *Collector) string {
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestCollector(t *testing.T) {
+	c := New(WithBuckets(1, 0.5))
+	for i := 0; i < 2; i++ {
+		fsm := newFSM(t)
+		c.Register(fsm)
+		fsm.EmitEvent("start")
+		fsm.EmitEvent("start")
+		fsm.EmitEvent("missing")
+	}
+
+	out := scrape(t, c)
+	for _, want := range []string{
+		"# TYPE yafsm_transitions_total counter\n",
+		`yafsm_transitions_total{event="start",from="idle",to="running",result="ok"} 2` + "\n",
+		`yafsm_rejections_total{event="missing",reason="event_not_exist"} 2` + "\n",
+		`yafsm_rejections_total{event="start",reason="illegal_state"} 2` + "\n",
+		"# TYPE yafsm_handler_duration_seconds histogram\n",
+		`yafsm_handler_duration_seconds_bucket{phase="enter",name="running",le="0.5"} 2` + "\n" +
+			`yafsm_handler_duration_seconds_bucket{phase="enter",name="running",le="1"} 2` + "\n" +
+			`yafsm_handler_duration_seconds_bucket{phase="enter",name="running",le="+Inf"} 2` + "\n",
+		`yafsm_handler_duration_seconds_count{phase="leave",name="idle"} 2` + "\n",
+		"yafsm_queue_available 2048\n",
+		"yafsm_fsms 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestCollectorClosed(t *testing.T) {
+	for _, opts := range [][]yafsm.FSMOption{nil, {yafsm.WithAsync()}} {
+		c := New()
+		fsm := newFSM(t, opts...)
+		c.Register(fsm)
+		fsm.Close()
+		fsm.EmitEvent("start")
+
+		want := `yafsm_rejections_total{event="start",reason="closed"} 1` + "\n"
+		if out := scrape(t, c); !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestCollectorQueueDepth(t *testing.T) {
+	c := New(WithNamespace("app"))
+	fsm := newFSM(t, yafsm.WithAsync())
+	defer fsm.Close()
+	c.Register(fsm)
+	entered, release := make(chan struct{}), make(chan struct{})
+	fsm.GetState("running").AddEnter(func(*yafsm.State) {
+		close(entered)
+		<-release
+	})
+
+	started := fsm.EmitEventAsync("start")
+	<-entered
+	fsm.EmitPrioEventAsync(5, "stop")
+	fsm.EmitEventAsync("stop")
+	fsm.EmitEventAsync("stop")
+
+	out := scrape(t, c)
+	for _, want := range []string{
+		`app_queue_depth{prio="1"} 2` + "\n",
+		`app_queue_depth{prio="5"} 1` + "\n",
+		"app_queue_available 1021\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	close(release)
+	<-started
+
+	c.Unregister(fsm)
+	if out := scrape(t, c); !strings.Contains(out, "app_fsms 0\n") {
+		t.Errorf("fsm should be unregistered\n%s", out)
+	}
+}
+
+func TestLabelsEscaped(t *testing.T) {
+	if got := labels("event", "a\"b\\c\nd"); got != `{event="a\"b\\c\nd"}` {
+		t.Fatalf("unexpected labels %s", got)
+	}
+}
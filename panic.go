@@ -0,0 +1,109 @@
+package yafsm
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is a recovered panic. Panics in hooks come wrapped in a
+// HandlerError, panics elsewhere, e.g. in guards or listeners, as is.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func newPanicError(p interface{}) *PanicError {
+	return &PanicError{Value: p, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the value panicked with if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// PanicPolicy decides the states an FSM ends in after a panicking hook.
+// Panics are always recovered and answered to the emitter as PanicError.
+type PanicPolicy int
+
+const (
+	// PanicAsFailure handles a panic like a hook returning an error, as the
+	// FailurePolicy says.
+	PanicAsFailure PanicPolicy = iota
+	// PanicKeep stays in the target states, without compensation.
+	PanicKeep
+	// PanicRollback restores the source states without running the
+	// compensating hooks, which may panic as well.
+	PanicRollback
+	// PanicFallback restores the source states, without compensation, and
+	// then sets the state given to WithPanicState in its region.
+	PanicFallback
+)
+
+func WithPanicPolicy(policy PanicPolicy) FSMOption {
+	return func(fsm *FSM) {
+		fsm.panicPolicy = policy
+	}
+}
+
+// WithPanicState sets the PanicFallback policy moving to state, e.g. an
+// "abnormal" state. Its hooks don't run.
+func WithPanicState(state string) FSMOption {
+	return func(fsm *FSM) {
+		fsm.panicPolicy = PanicFallback
+		fsm.panicState = state
+	}
+}
+
+type failureAction int
+
+const (
+	actionKeep failureAction = iota
+	// rollback and compensate
+	actionRollback
+	// rollback only
+	actionRevert
+	actionFallback
+)
+
+// failureAction decides what follows an emission failing with err.
+func (fsm *FSM) failureAction(err error) failureAction {
+	if err == nil {
+		return actionKeep
+	}
+	pe := (*PanicError)(nil)
+	if errors.As(err, &pe) && fsm.panicPolicy != PanicAsFailure {
+		switch fsm.panicPolicy {
+		case PanicKeep:
+			return actionKeep
+		case PanicRollback:
+			return actionRevert
+		case PanicFallback:
+			return actionFallback
+		}
+	}
+	if fsm.failure == FailureRollback {
+		return actionRollback
+	}
+	return actionKeep
+}
+
+// fallback reverts trs and moves to the panic state, returning the states
+// set. fsm.mutex must be held.
+func (fsm *FSM) fallback(trs []*Transition) map[string]string {
+	fsm.rollback(trs)
+	states := make(map[string]string, len(trs))
+	for _, tr := range trs {
+		states[tr.Region] = fsm.current(tr.Region)
+	}
+	if st, ok := fsm.states[fsm.panicState]; ok {
+		fsm.setCurrent(st.region, st.State)
+		states[st.region] = st.State
+	}
+	return states
+}
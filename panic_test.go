@@ -0,0 +1,89 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPanicAsync(t *testing.T) {
+	fsm := newLinkAuthFSM(t, WithAsync())
+	defer fsm.Close()
+	errBoom := errors.New("boom")
+	fsm.GetState("up").AddEnter(func(*State) { panic(errBoom) })
+
+	first := fsm.EmitEventAsync("connect")
+	second := fsm.EmitEventAsync("login")
+	err := <-first
+	he, pe := (*HandlerError)(nil), (*PanicError)(nil)
+	if !errors.As(err, &he) || he.Phase != PhaseEnter || he.Name != "up" ||
+		!errors.As(err, &pe) || len(pe.Stack) == 0 || !errors.Is(err, errBoom) {
+		t.Fatalf("want a panic in up's enter hook, got %v", err)
+	}
+	// the dispatcher survived
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent("disconnect"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanicOutsideHooks(t *testing.T) {
+	fsm := newLinkAuthFSM(t, WithAsync())
+	defer fsm.Close()
+	fsm.GetEvents("connect")[0].AddGuard(func(context.Context, *Event) error { panic("guard") })
+
+	pe := (*PanicError)(nil)
+	if err := fsm.EmitEvent("connect"); !errors.As(err, &pe) || pe.Value != "guard" {
+		t.Fatalf("want PanicError, got %v", err)
+	}
+	if err := fsm.EmitEvent("login"); err != nil || fsm.State() != "down" {
+		t.Fatalf("dispatcher should survive, got %v in %s", err, fsm.State())
+	}
+}
+
+func TestPanicPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		opts        []FSMOption
+		state       string
+		compensated bool
+	}{
+		{"failure keep", nil, "up", false},
+		{"failure rollback", []FSMOption{WithFailurePolicy(FailureRollback)}, "down", true},
+		{"keep", []FSMOption{WithFailurePolicy(FailureRollback), WithPanicPolicy(PanicKeep)}, "up", false},
+		{"rollback", []FSMOption{WithPanicPolicy(PanicRollback)}, "down", false},
+		{"fallback", []FSMOption{WithPanicState("abnormal")}, "abnormal", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, mode := range [][]FSMOption{nil, {WithInSeq()}, {WithAsync()}} {
+				fsm := newLinkAuthFSM(t, append(tc.opts, mode...)...)
+				fsm.AddState("abnormal")
+				compensated := false
+				fsm.GetState("down").AddEnter(func(*State) { compensated = true })
+				fsm.GetState("up").AddEnter(func(*State) { panic("boom") })
+
+				pe := (*PanicError)(nil)
+				if err := fsm.EmitEvent("connect"); !errors.As(err, &pe) {
+					t.Fatalf("want PanicError, got %v", err)
+				}
+				if fsm.State() != tc.state || compensated != tc.compensated {
+					t.Fatalf("want %s compensated %v, got %s %v", tc.state, tc.compensated, fsm.State(), compensated)
+				}
+				fsm.Close()
+			}
+		})
+	}
+}
+
+func TestPanicFallbackPersisted(t *testing.T) {
+	store := &memStore{}
+	fsm := newLinkAuthFSM(t, WithStore(store), WithPanicState("abnormal"))
+	fsm.AddState("abnormal")
+	fsm.GetState("up").AddEnter(func(*State) { panic("boom") })
+	fsm.EmitEvent("connect")
+	if len(store.entries) != 1 || store.entries[0].States[""] != "abnormal" {
+		t.Fatalf("unexpected entries %+v", store.entries)
+	}
+}
@@ -8,6 +8,11 @@ import (
 	"sync/atomic"
 )
 
+var (
+	ErrQueueClosed = errors.New("queue closed")
+	ErrQueueFull   = errors.New("queue full")
+)
+
 type OptionPrioQueue func(*PrioQueue) error
 
 func OptionQueueLen(length int) OptionPrioQueue {
@@ -75,13 +80,13 @@ func (pq *PrioQueue) PrioPush(prio int, data interface{}) error {
 	pq.mutex.RLock()
 	if !pq.ok {
 		pq.mutex.RUnlock()
-		return errors.New("queue closed")
+		return ErrQueueClosed
 	}
 	select {
 	case pq.ch <- struct{}{}:
 	default:
 		pq.mutex.RUnlock()
-		return errors.New("queue full")
+		return ErrQueueFull
 	}
 
 	queue := (*prioQueue)(nil)
@@ -135,13 +140,13 @@ func (pq *PrioQueue) Push(data interface{}) error {
 	pq.mutex.RLock()
 	if !pq.ok {
 		pq.mutex.RUnlock()
-		return errors.New("queue closed")
+		return ErrQueueClosed
 	}
 	select {
 	case pq.ch <- struct{}{}:
 	default:
 		pq.mutex.RUnlock()
-		return errors.New("queue full")
+		return ErrQueueFull
 	}
 	queue := (*prioQueue)(nil)
 	for elem := pq.queues.Front(); elem != nil; elem = elem.Next() {
@@ -190,6 +195,24 @@ func (pq *PrioQueue) PopSync() interface{} {
 	if !ok {
 		return nil
 	}
+	return pq.take()
+}
+
+// TryPop is a non-blocking PopSync, it returns nil if nothing is queued.
+func (pq *PrioQueue) TryPop() interface{} {
+	select {
+	case _, ok := <-pq.ch:
+		if !ok {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return pq.take()
+}
+
+// take pops the data a token was taken for, which may not be pushed yet.
+func (pq *PrioQueue) take() interface{} {
 	for {
 		queue := (*prioQueue)(nil)
 		pq.mutex.RLock()
@@ -218,3 +241,26 @@ func (pq *PrioQueue) Close() {
 	close(pq.ch)
 	pq.ok = false
 }
+
+// Range calls f for the queued data in pop order, highest priority and then
+// oldest first, until f returns false. Data pushed or popped meanwhile may or
+// may not be seen.
+func (pq *PrioQueue) Range(f func(prio int, data interface{}) bool) {
+	pq.mutex.RLock()
+	defer pq.mutex.RUnlock()
+
+	for elem := pq.queues.Back(); elem != nil; elem = elem.Prev() {
+		queue, _ := elem.Value.(*prioQueue)
+		queue.mutex.Lock()
+		values := make([]interface{}, 0, queue.Len())
+		for data := queue.Back(); data != nil; data = data.Prev() {
+			values = append(values, data.Value)
+		}
+		queue.mutex.Unlock()
+		for _, value := range values {
+			if !f(queue.prio, value) {
+				return
+			}
+		}
+	}
+}
@@ -84,3 +84,70 @@ func TestPrioQueuePopSync(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestPrioQueueRange(t *testing.T) {
+	pq, err := NewPrioQueue()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pq.PrioPush(1, "foo")
+	pq.PrioPush(99, "bza")
+	pq.PrioPush(1, "bar")
+	pq.PrioPush(-1, "baz")
+
+	values := []interface{}{}
+	pq.Range(func(prio int, data interface{}) bool {
+		values = append(values, data)
+		return true
+	})
+	if fmt.Sprint(values) != "[bza foo bar baz]" {
+		t.Errorf("unexpected order %v", values)
+	}
+	for _, value := range values {
+		if data := pq.Pop(); data != value {
+			t.Errorf("range order %v differs from pop order at %v", values, data)
+		}
+	}
+
+	count := 0
+	pq.PrioPush(1, "foo")
+	pq.PrioPush(1, "bar")
+	pq.Range(func(prio int, data interface{}) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("range should stop, called %d times", count)
+	}
+}
+
+func TestPrioQueueTryPop(t *testing.T) {
+	pq, err := NewPrioQueue(OptionQueueLen(2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if data := pq.TryPop(); data != nil {
+		t.Errorf("empty queue popped %v", data)
+	}
+	pq.PrioPush(1, "foo")
+	pq.PrioPush(2, "bar")
+	if data := pq.TryPop(); data != "bar" {
+		t.Errorf("want bar, got %v", data)
+	}
+	if data := pq.TryPop(); data != "foo" {
+		t.Errorf("want foo, got %v", data)
+	}
+	// the slots are free again
+	if err := pq.PrioPush(1, "foo"); err != nil {
+		t.Error(err)
+	}
+	pq.Close()
+	if data := pq.TryPop(); data != "foo" {
+		t.Errorf("queued data should still pop after Close, got %v", data)
+	}
+	if data := pq.TryPop(); data != nil {
+		t.Errorf("closed queue popped %v", data)
+	}
+}
@@ -0,0 +1,97 @@
+package yafsm
+
+// defaultRegions is what regionNames returns for an FSM without named
+// regions, it saves an allocation per emission.
+var defaultRegions = []string{""}
+
+// AddRegion adds an orthogonal region starting in initial. Every region keeps
+// its own current state, states added to it with AddRegionState or
+// AddSubState only take part in events of that region, and a single emission
+// moves every region in which the event is legal.
+func (fsm *FSM) AddRegion(region, initial string) (*State, error) {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if region == "" {
+		return nil, ErrRegionDuplicated
+	}
+	if _, ok := fsm.regions[region]; ok {
+		return nil, ErrRegionDuplicated
+	}
+	st, ok := fsm.states[initial]
+	if ok && st.region != region {
+		return nil, ErrRegionMismatch
+	}
+	if !ok {
+		st = &State{State: initial, region: region}
+		fsm.states[initial] = st
+	}
+	fsm.regions[region] = initial
+	fsm.initials[region] = initial
+	fsm.regionOrder = append(fsm.regionOrder, region)
+	return st, nil
+}
+
+func (fsm *FSM) AddRegionState(region, state string) (*State, error) {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if _, ok := fsm.regions[region]; !ok && region != "" {
+		return nil, ErrRegionNotExist
+	}
+	st, ok := fsm.states[state]
+	if ok && st.region != region {
+		return nil, ErrRegionMismatch
+	}
+	if !ok {
+		st = &State{State: state, region: region}
+		fsm.states[state] = st
+	}
+	return st, nil
+}
+
+// RegionState returns the current state of region, "" is the default region.
+func (fsm *FSM) RegionState(region string) string {
+	fsm.mutex.RLock()
+	defer fsm.mutex.RUnlock()
+	return fsm.current(region)
+}
+
+// ActiveStates returns the current state of every region, the default region
+// first and then in the order regions were added.
+func (fsm *FSM) ActiveStates() []string {
+	fsm.mutex.RLock()
+	defer fsm.mutex.RUnlock()
+
+	states := make([]string, 0, len(fsm.regionOrder)+1)
+	for _, region := range fsm.regionNames() {
+		states = append(states, fsm.current(region))
+	}
+	return states
+}
+
+func (st *State) Region() string {
+	return st.region
+}
+
+func (fsm *FSM) regionNames() []string {
+	if len(fsm.regionOrder) == 0 {
+		return defaultRegions
+	}
+	return append([]string{""}, fsm.regionOrder...)
+}
+
+func (fsm *FSM) current(region string) string {
+	if region == "" {
+		return fsm.state
+	}
+	return fsm.regions[region]
+}
+
+func (fsm *FSM) setCurrent(region, state string) {
+	if region == "" {
+		fsm.state = state
+		return
+	}
+	fsm.regions[region] = state
+}
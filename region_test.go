@@ -0,0 +1,148 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newLinkAuthFSM tracks link state in the default region and auth state in
+// the "auth" region, "disconnect" drives both.
+func newLinkAuthFSM(t *testing.T, opts ...FSMOption) *FSM {
+	fsm := NewFSM(opts...)
+	down := fsm.Init("down")
+	up := fsm.AddState("up")
+	anon, err := fsm.AddRegion("auth", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed, err := fsm.AddRegionState("auth", "authenticated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []struct {
+		name     string
+		from, to *State
+	}{
+		{"connect", down, up},
+		{"login", anon, authed},
+		{"disconnect", up, down},
+		{"disconnect", authed, anon},
+	} {
+		if _, err := fsm.AddEvent(ev.name, ev.from, ev.to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fsm
+}
+
+func TestRegionsMoveIndependently(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "anonymous"}) {
+		t.Fatalf("ActiveStates = %v", got)
+	}
+	if err := fsm.EmitEvent("connect"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent("login"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.State() != "up" || fsm.RegionState("auth") != "authenticated" {
+		t.Fatalf("ActiveStates = %v", fsm.ActiveStates())
+	}
+	if !fsm.InStates("authenticated") {
+		t.Fatal("InStates should look at every region")
+	}
+	if err := fsm.EmitEvent("disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "anonymous"}) {
+		t.Fatalf("ActiveStates after disconnect = %v", got)
+	}
+	if err := fsm.EmitEvent("login"); err != nil {
+		t.Fatal(err)
+	}
+	// only the auth region has a legal disconnect from here
+	if err := fsm.EmitEvent("disconnect"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent("disconnect"); !errors.Is(err, ErrIllegalStateForEvent) {
+		t.Fatalf("want ErrIllegalStateForEvent, got %v", err)
+	}
+}
+
+func TestRegionsGuardIsAtomic(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("login")
+	auth := fsm.GetEvent("disconnect", fsm.GetState("authenticated"), fsm.GetState("anonymous"))
+	auth.AddGuard(func(context.Context, *Event) error { return errors.New("session pinned") })
+
+	if err := fsm.EmitEvent("disconnect"); !errors.Is(err, ErrGuardRejected) {
+		t.Fatalf("want ErrGuardRejected, got %v", err)
+	}
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "authenticated"}) {
+		t.Fatalf("no region may move on a rejected guard: %v", got)
+	}
+}
+
+func TestRegionsRollbackIsAtomic(t *testing.T) {
+	fsm := newLinkAuthFSM(t, WithFailurePolicy(FailureRollback))
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("login")
+	fsm.GetState("anonymous").AddEnterE(func(context.Context, *State) error { return errors.New("boom") })
+
+	if err := fsm.EmitEvent("disconnect"); err == nil {
+		t.Fatal("want handler error")
+	}
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "authenticated"}) {
+		t.Fatalf("all regions should roll back: %v", got)
+	}
+}
+
+func TestRegionsKeepOnlyFiredRegions(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	fsm.AddEvent("boot", fsm.GetState("down"), fsm.GetState("up"))
+	fsm.AddEvent("boot", fsm.GetState("anonymous"), fsm.GetState("authenticated"))
+	fsm.GetState("up").AddEnterE(func(context.Context, *State) error { return errors.New("boom") })
+	entered := false
+	authed := fsm.GetState("authenticated")
+	authed.AddEnter(func(*State) { entered = true })
+	authed.SetTimeout(time.Second, "disconnect")
+
+	if err := fsm.EmitEvent("boot"); err == nil {
+		t.Fatal("want handler error")
+	}
+	// the failing region keeps its state, the one whose hooks never ran
+	// doesn't move
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "anonymous"}) {
+		t.Fatalf("ActiveStates = %v", got)
+	}
+	if entered {
+		t.Fatal("authenticated shouldn't be entered")
+	}
+	if _, ok := fsm.timeouts[authed]; ok {
+		t.Fatal("authenticated timeout armed")
+	}
+}
+
+func TestRegionErrors(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	if _, err := fsm.AddRegion("auth", "x"); !errors.Is(err, ErrRegionDuplicated) {
+		t.Fatalf("want ErrRegionDuplicated, got %v", err)
+	}
+	if _, err := fsm.AddRegionState("missing", "x"); !errors.Is(err, ErrRegionNotExist) {
+		t.Fatalf("want ErrRegionNotExist, got %v", err)
+	}
+	if _, err := fsm.AddRegionState("auth", "up"); !errors.Is(err, ErrRegionMismatch) {
+		t.Fatalf("want ErrRegionMismatch, got %v", err)
+	}
+	if _, err := fsm.AddEvent("cross", fsm.GetState("up"), fsm.GetState("anonymous")); !errors.Is(err, ErrRegionMismatch) {
+		t.Fatalf("want ErrRegionMismatch, got %v", err)
+	}
+	if !fsm.SetState("authenticated") || fsm.RegionState("auth") != "authenticated" || fsm.State() != "down" {
+		t.Fatalf("SetState should target the state's region: %v", fsm.ActiveStates())
+	}
+}
@@ -0,0 +1,110 @@
+package yafsm
+
+import (
+	"context"
+	"fmt"
+)
+
+type ReplayMode int
+
+const (
+	// ReplaySilent only moves the states, no hook nor completion callback
+	// runs.
+	ReplaySilent ReplayMode = iota
+	// ReplayHooks runs hooks and the completion callback as usual, their
+	// context tells them apart with IsReplay.
+	ReplayHooks
+)
+
+type replayKey struct{}
+
+// IsReplay reports whether ctx, given to guards and error-returning
+// handlers, belongs to an emission replayed by Replay.
+func IsReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey{}).(bool)
+	return replay
+}
+
+// WithEventSourcing makes the store's event log authoritative: Recover
+// replays its entries through the pipeline in mode instead of setting the
+// states they recorded.
+func WithEventSourcing(mode ReplayMode) FSMOption {
+	return func(fsm *FSM) {
+		fsm.sourcing = true
+		fsm.replayMode = mode
+	}
+}
+
+// Replay emits the events of entries in order, bypassing the queue. Guards
+// aren't evaluated, the entries were let through once, and nothing is
+// persisted. After each entry the states it recorded, if any, are checked so
+// a definition no longer matching the log yields ErrReplayDiverged. Fallback
+// entries only set their states, without hooks.
+func (fsm *FSM) Replay(entries []Entry, mode ReplayMode) error {
+	ctx := context.WithValue(context.Background(), replayKey{}, true)
+	fsm.mutex.RLock()
+	listeners := fsm.listeners
+	fsm.mutex.RUnlock()
+	for i, entry := range entries {
+		if entry.Fallback {
+			if err := fsm.fallTo(entry.States); err != nil {
+				return fmt.Errorf("entry %d: %s: %w", i, entry.Event, err)
+			}
+			continue
+		}
+		ec := &eventchan{
+			ctx:       ctx,
+			event:     entry.Event,
+			args:      entry.Args,
+			prio:      entry.Prio,
+			ch:        make(chan error, 1),
+			replaying: true,
+			hooks:     mode == ReplayHooks,
+			em: &Emission{
+				Event:  entry.Event,
+				Prio:   entry.Prio,
+				Args:   entry.Args,
+				Mode:   "replay",
+				Queued: fsm.clock.Now(),
+			},
+			listeners: listeners,
+		}
+		if fsm.inseq {
+			fsm.mutex.Lock()
+			fsm.handle(ec, true)
+			fsm.mutex.Unlock()
+		} else {
+			fsm.handle(ec, false)
+		}
+		if err := <-ec.ch; err != nil {
+			return fmt.Errorf("entry %d: %s: %w", i, entry.Event, err)
+		}
+
+		fsm.mutex.RLock()
+		for region, state := range entry.States {
+			if cur := fsm.current(region); cur != state {
+				fsm.mutex.RUnlock()
+				return fmt.Errorf("%w: entry %d: %s: region %q in %q, want %q",
+					ErrReplayDiverged, i, entry.Event, region, cur, state)
+			}
+		}
+		fsm.mutex.RUnlock()
+	}
+	return nil
+}
+
+// fallTo sets the states of a fallback entry.
+func (fsm *FSM) fallTo(states map[string]string) error {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	for region, state := range states {
+		st, ok := fsm.states[state]
+		if !ok || st.region != region {
+			return fmt.Errorf("%w: region %q: state %q: %w", ErrReplayDiverged, region, state, ErrStateNotExist)
+		}
+	}
+	for region, state := range states {
+		fsm.setCurrent(region, state)
+	}
+	return nil
+}
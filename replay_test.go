@@ -0,0 +1,109 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	store := &memStore{}
+	fsm := newLinkAuthFSM(t, WithStore(store))
+	fsm.EmitEvent("connect")
+	fsm.EmitEventWithArgs("login", "alice")
+	fsm.EmitEvent("disconnect")
+	fsm.EmitEvent("connect")
+	if len(store.entries) != 4 {
+		t.Fatalf("want 4 entries, got %d", len(store.entries))
+	}
+
+	for _, mode := range []ReplayMode{ReplaySilent, ReplayHooks} {
+		users, replayed := []interface{}{}, 0
+		replica := newLinkAuthFSM(t, WithStore(&memStore{}))
+		replica.GetState("authenticated").AddEnterE(func(ctx context.Context, st *State) error {
+			users = append(users, TransitionFromContext(ctx).Args...)
+			if IsReplay(ctx) {
+				replayed++
+			}
+			return nil
+		})
+		replica.GetEvents("login")[0].AddGuard(func(context.Context, *Event) error {
+			return errors.New("guards don't run on replay")
+		})
+		if err := replica.Replay(store.entries, mode); err != nil {
+			t.Fatal(err)
+		}
+		if got := replica.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "anonymous"}) {
+			t.Fatalf("unexpected states %v", got)
+		}
+		if mode == ReplaySilent && len(users) != 0 {
+			t.Fatalf("hooks ran in silent mode: %v", users)
+		}
+		if mode == ReplayHooks && (!reflect.DeepEqual(users, []interface{}{"alice"}) || replayed != 1) {
+			t.Fatalf("hooks: %v, replayed %d", users, replayed)
+		}
+		if entries := replica.store.(*memStore).entries; len(entries) != 0 {
+			t.Fatalf("replay shouldn't persist, got %+v", entries)
+		}
+	}
+}
+
+func TestReplayDiverged(t *testing.T) {
+	entries := []Entry{
+		{Event: "connect", States: map[string]string{"": "up"}},
+		{Event: "login", States: map[string]string{"auth": "anonymous"}},
+	}
+	fsm := newLinkAuthFSM(t)
+	if err := fsm.Replay(entries, ReplaySilent); !errors.Is(err, ErrReplayDiverged) {
+		t.Fatalf("want ErrReplayDiverged, got %v", err)
+	}
+	fsm = newLinkAuthFSM(t)
+	if err := fsm.Replay([]Entry{{Event: "login"}, {Event: "login"}}, ReplaySilent); !errors.Is(err, ErrIllegalStateForEvent) {
+		t.Fatalf("want ErrIllegalStateForEvent, got %v", err)
+	}
+}
+
+func TestRecoverEventSourcing(t *testing.T) {
+	store := &memStore{}
+	fsm := newLinkAuthFSM(t, WithStore(store))
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("login")
+
+	entered := 0
+	recovered := newLinkAuthFSM(t, WithStore(store), WithEventSourcing(ReplayHooks))
+	recovered.GetState("up").AddEnter(func(*State) { entered++ })
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if got := recovered.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "authenticated"}) || entered != 1 {
+		t.Fatalf("unexpected states %v, entered %d", got, entered)
+	}
+}
+
+func TestRecoverPanicFallback(t *testing.T) {
+	for _, mode := range []ReplayMode{ReplaySilent, ReplayHooks} {
+		newFSM := func(store Store) *FSM {
+			fsm := newLinkAuthFSM(t, WithStore(store), WithEventSourcing(mode), WithPanicState("abnormal"))
+			fsm.AddState("abnormal")
+			fsm.GetState("up").AddEnter(func(*State) { panic("boom") })
+			return fsm
+		}
+		store := &memStore{}
+		fsm := newFSM(store)
+		fsm.EmitEvent("connect")
+		fsm.EmitEvent("login")
+		if len(store.entries) != 2 || !store.entries[0].Fallback || store.entries[1].Fallback {
+			t.Fatalf("unexpected entries %+v", store.entries)
+		}
+
+		// the fallback is applied, not replayed into up
+		recovered := newFSM(store)
+		if err := recovered.Recover(); err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		if got := recovered.ActiveStates(); !reflect.DeepEqual(got, []string{"abnormal", "authenticated"}) {
+			t.Fatalf("mode %d: unexpected states %v", mode, got)
+		}
+	}
+}
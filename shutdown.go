@@ -0,0 +1,62 @@
+package yafsm
+
+import "context"
+
+// Shutdown stops accepting events, which then fail with ErrFSMClosed, and
+// lets the queued ones run until ctx is done. The events still queued by then
+// are answered with ErrFSMClosed and counted as dropped. A transition in
+// flight can't be interrupted, Shutdown always waits for it to end before
+// releasing the FSM. It returns ctx.Err() if the queue didn't drain in time,
+// ErrFSMClosed if the FSM was already closed.
+func (fsm *FSM) Shutdown(ctx context.Context) (int, error) {
+	if !fsm.stop() {
+		return 0, ErrFSMClosed
+	}
+	drained := make(chan struct{})
+	go func() {
+		fsm.pending.Wait()
+		close(drained)
+	}()
+	dropped, err := 0, error(nil)
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		dropped = fsm.drop()
+		<-drained
+	}
+	fsm.release()
+	return dropped, err
+}
+
+// stop makes push reject new events, it returns false if already stopped.
+func (fsm *FSM) stop() bool {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	if fsm.closed {
+		return false
+	}
+	fsm.closed = true
+	fsm.stopTimers()
+	return true
+}
+
+// drop answers the events still queued with ErrFSMClosed and returns how many
+// there were.
+func (fsm *FSM) drop() int {
+	dropped := 0
+	for data := fsm.pq.TryPop(); data != nil; data = fsm.pq.TryPop() {
+		ec, ok := data.(*eventchan)
+		if !ok || !ec.run() {
+			// cancelled meanwhile
+			continue
+		}
+		fsm.mutex.RLock()
+		from := fsm.state
+		fsm.mutex.RUnlock()
+		fsm.rejected(ec, fsm.clock.Now(), from, ErrFSMClosed)
+		ec.reply(ErrFSMClosed)
+		dropped++
+	}
+	return dropped
+}
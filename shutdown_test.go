@@ -0,0 +1,124 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newBlockedFSM returns an async FSM whose worker is blocked entering up
+// until release is closed, with connected answering that emission.
+func newBlockedFSM(t *testing.T) (fsm *FSM, release chan struct{}, connected <-chan error) {
+	fsm = newLinkAuthFSM(t, WithAsync())
+	entered, release := make(chan struct{}), make(chan struct{})
+	fsm.GetState("up").AddEnter(func(*State) {
+		close(entered)
+		<-release
+	})
+	connected = fsm.EmitEventAsync("connect")
+	<-entered
+	return fsm, release, connected
+}
+
+func TestShutdownDrains(t *testing.T) {
+	fsm, release, connected := newBlockedFSM(t)
+	login := fsm.EmitEventAsync("login")
+	disconnect := fsm.EmitEventAsync("disconnect")
+
+	type result struct {
+		dropped int
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		dropped, err := fsm.Shutdown(context.Background())
+		done <- result{dropped, err}
+	}()
+	for !func() bool {
+		fsm.mutex.RLock()
+		defer fsm.mutex.RUnlock()
+		return fsm.closed
+	}() {
+		time.Sleep(time.Millisecond)
+	}
+	// new events are refused as soon as Shutdown starts
+	if err := <-fsm.EmitEventAsync("login"); !errors.Is(err, ErrFSMClosed) {
+		t.Fatalf("want ErrFSMClosed, got %v", err)
+	}
+	close(release)
+	for _, ch := range []<-chan error{connected, login, disconnect} {
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := <-done; r.dropped != 0 || r.err != nil {
+		t.Fatalf("unexpected shutdown %+v", r)
+	}
+	if _, err := fsm.Shutdown(context.Background()); !errors.Is(err, ErrFSMClosed) {
+		t.Fatalf("want ErrFSMClosed, got %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	fsm, release, connected := newBlockedFSM(t)
+	queued := []<-chan error{
+		fsm.EmitEventAsync("login"),
+		fsm.EmitPrioEventAsync(2, "disconnect"),
+		fsm.EmitEventAsync("login"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go func() {
+		// the queued events are dropped before the transition in flight ends
+		for _, ch := range queued {
+			if err := <-ch; !errors.Is(err, ErrFSMClosed) {
+				t.Errorf("want ErrFSMClosed, got %v", err)
+			}
+		}
+		close(release)
+	}()
+	dropped, err := fsm.Shutdown(ctx)
+	if dropped != 3 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want 3 dropped on deadline, got %d %v", dropped, err)
+	}
+	// Shutdown waited for it
+	select {
+	case err := <-connected:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("in flight transition should have ended")
+	}
+}
+
+func TestCloseAnswersQueued(t *testing.T) {
+	fsm, release, connected := newBlockedFSM(t)
+	login := fsm.EmitEventAsync("login")
+	fsm.Close()
+	if err := <-login; !errors.Is(err, ErrFSMClosed) {
+		t.Fatalf("want ErrFSMClosed, got %v", err)
+	}
+	close(release)
+	<-connected
+	if err := fsm.EmitEvent("login"); !errors.Is(err, ErrFSMClosed) {
+		t.Fatalf("want ErrFSMClosed, got %v", err)
+	}
+	// closing again is harmless
+	fsm.Close()
+}
+
+func TestShutdownSync(t *testing.T) {
+	for _, opts := range [][]FSMOption{nil, {WithInSeq()}} {
+		fsm := newLinkAuthFSM(t, opts...)
+		fsm.EmitEvent("connect")
+		if dropped, err := fsm.Shutdown(context.Background()); dropped != 0 || err != nil {
+			t.Fatalf("unexpected shutdown %d %v", dropped, err)
+		}
+		if err := fsm.EmitEvent("disconnect"); !errors.Is(err, ErrFSMClosed) {
+			t.Fatalf("want ErrFSMClosed, got %v", err)
+		}
+	}
+}
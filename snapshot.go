@@ -0,0 +1,216 @@
+package yafsm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is the serialisable runtime state of an FSM, taken by Snapshot
+// and applied by Restore. It encodes to JSON with encoding/json and to a
+// binary form with MarshalBinary. Event args are kept as they are, in the
+// binary form their concrete types must be registered with gob.Register.
+type Snapshot struct {
+	// Version identifies the definition the snapshot was taken from.
+	Version string `json:"version"`
+	// States is the current state by region, "" being the default region.
+	States    map[string]string `json:"states"`
+	Completed bool              `json:"completed,omitempty"`
+	History   []Record          `json:"history,omitempty"`
+	// Pending are the events still queued, in the order they'd run.
+	Pending []PendingEvent `json:"pending,omitempty"`
+	// Seq is the last state change included, see Entry.Seq.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Covers reports whether entry is included in the snapshot.
+func (s *Snapshot) Covers(entry Entry) bool {
+	return entry.Seq <= s.Seq
+}
+
+type PendingEvent struct {
+	Event string        `json:"event"`
+	Prio  int           `json:"prio"`
+	Args  []interface{} `json:"args,omitempty"`
+}
+
+// snapshotWire keeps gob from calling MarshalBinary recursively.
+type snapshotWire Snapshot
+
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode((*snapshotWire)(s)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode((*snapshotWire)(s))
+}
+
+// recordWire is a Record with its error flattened to the message, decoding
+// gives back an error with the same message but not the same identity.
+type recordWire struct {
+	Event  string    `json:"event"`
+	Region string    `json:"region,omitempty"`
+	From   string    `json:"from"`
+	To     string    `json:"to,omitempty"`
+	Prio   int       `json:"prio"`
+	Time   time.Time `json:"time"`
+	Err    string    `json:"err,omitempty"`
+}
+
+func (r Record) wire() recordWire {
+	w := recordWire{r.Event, r.Region, r.From, r.To, r.Prio, r.Time, ""}
+	if r.Err != nil {
+		w.Err = r.Err.Error()
+	}
+	return w
+}
+
+func (w recordWire) record() Record {
+	r := Record{w.Event, w.Region, w.From, w.To, w.Prio, w.Time, nil}
+	if w.Err != "" {
+		r.Err = errors.New(w.Err)
+	}
+	return r
+}
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.wire())
+}
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+	w := recordWire{}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*r = w.record()
+	return nil
+}
+
+func (r Record) GobEncode() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(r.wire()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *Record) GobDecode(data []byte) error {
+	w := recordWire{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&w); err != nil {
+		return err
+	}
+	*r = w.record()
+	return nil
+}
+
+// Version fingerprints the definition of fsm, its states with their final,
+// terminal and timeout settings, regions and events, as exported by
+// ExportDefinition. Hooks added in code aren't part of it.
+func (fsm *FSM) Version() string {
+	data, _ := json.Marshal(fsm.ExportDefinition())
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Snapshot captures the current states, the history if enabled and the
+// events still queued. Emissions running meanwhile may or may not be seen,
+// if one is undone afterwards an entry setting its states back is persisted.
+func (fsm *FSM) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Version: fsm.Version(),
+		States:  make(map[string]string),
+		History: fsm.History(),
+	}
+	fsm.mutex.RLock()
+	for _, region := range fsm.regionNames() {
+		snapshot.States[region] = fsm.current(region)
+	}
+	snapshot.Completed = fsm.completed
+	snapshot.Seq = fsm.seq
+	for snapped := fsm.snapped.Load(); snapped < snapshot.Seq; snapped = fsm.snapped.Load() {
+		if fsm.snapped.CompareAndSwap(snapped, snapshot.Seq) {
+			break
+		}
+	}
+	fsm.mutex.RUnlock()
+
+	fsm.pq.Range(func(prio int, data interface{}) bool {
+		ec := data.(*eventchan)
+		if atomic.LoadInt32(&ec.state) == ecQueued {
+			snapshot.Pending = append(snapshot.Pending, PendingEvent{
+				Event: ec.event,
+				Prio:  ec.prio,
+				Args:  ec.args,
+			})
+		}
+		return true
+	})
+	return snapshot
+}
+
+// Restore validates snapshot against the definition of fsm and applies it,
+// without running any hook. The pending events are then emitted again, their
+// results only show in the history. An FSM already complete can't be
+// restored.
+func (fsm *FSM) Restore(snapshot *Snapshot) error {
+	if err := fsm.restore(snapshot); err != nil {
+		return err
+	}
+	for _, pending := range snapshot.Pending {
+		fsm.push(context.Background(), pending.Prio, pending.Event, pending.Args)
+	}
+	return nil
+}
+
+func (fsm *FSM) restore(snapshot *Snapshot) error {
+	if version := fsm.Version(); snapshot.Version != version {
+		return fmt.Errorf("%w: version %s, want %s", ErrSnapshotMismatch, snapshot.Version, version)
+	}
+	fsm.mutex.Lock()
+	if fsm.completed {
+		fsm.mutex.Unlock()
+		return ErrFSMDone
+	}
+	regions := fsm.regionNames()
+	if len(snapshot.States) != len(regions) {
+		fsm.mutex.Unlock()
+		return fmt.Errorf("%w: %d regions, want %d", ErrSnapshotMismatch, len(snapshot.States), len(regions))
+	}
+	for _, region := range regions {
+		state, ok := snapshot.States[region]
+		if !ok {
+			fsm.mutex.Unlock()
+			return fmt.Errorf("%w: region %q: %w", ErrSnapshotMismatch, region, ErrRegionNotExist)
+		}
+		st, ok := fsm.states[state]
+		if !ok || st.region != region {
+			fsm.mutex.Unlock()
+			return fmt.Errorf("%w: region %q: state %q: %w", ErrSnapshotMismatch, region, state, ErrStateNotExist)
+		}
+	}
+	for region, state := range snapshot.States {
+		fsm.setCurrent(region, state)
+	}
+	fsm.seq = snapshot.Seq
+	if snapshot.Completed {
+		fsm.completed = true
+		close(fsm.done)
+	}
+	fsm.mutex.Unlock()
+
+	if fsm.history != nil {
+		fsm.history.reset(snapshot.History)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package yafsm
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	fsm := newLinkAuthFSM(t, WithAsync(), WithHistory(16))
+	defer fsm.Close()
+	entered, release := make(chan struct{}), make(chan struct{})
+	fsm.GetState("up").AddEnter(func(*State) {
+		close(entered)
+		<-release
+	})
+
+	connected := fsm.EmitEventAsync("connect")
+	<-entered
+	fsm.EmitEventAsyncWithArgs("login", "alice")
+	fsm.EmitPrioEventAsync(2, "disconnect")
+
+	snapshot := fsm.Snapshot()
+	close(release)
+	if err := <-connected; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot.States, map[string]string{"": "up", "auth": "anonymous"}) {
+		t.Fatalf("unexpected states %v", snapshot.States)
+	}
+	want := []PendingEvent{{"disconnect", 2, nil}, {"login", 1, []interface{}{"alice"}}}
+	if !reflect.DeepEqual(snapshot.Pending, want) {
+		t.Fatalf("unexpected pending %+v", snapshot.Pending)
+	}
+
+	restored := newLinkAuthFSM(t, WithHistory(16))
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	// disconnect runs first, then login from anonymous
+	if got := restored.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "authenticated"}) {
+		t.Fatalf("unexpected states %v", got)
+	}
+	if records := restored.HistoryByEvent("login"); len(records) != 1 || records[0].Err != nil {
+		t.Fatalf("unexpected history %v", records)
+	}
+}
+
+func TestSnapshotEncoding(t *testing.T) {
+	fsm := newLinkAuthFSM(t, WithHistory(4))
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("login")
+	fsm.EmitEvent("missing")
+	snapshot := fsm.Snapshot()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON := &Snapshot{}
+	if err := json.Unmarshal(data, fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	data, err = snapshot.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromBinary := &Snapshot{}
+	if err := fromBinary.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, decoded := range []*Snapshot{fromJSON, fromBinary} {
+		if decoded.Version != snapshot.Version || !reflect.DeepEqual(decoded.States, snapshot.States) {
+			t.Fatalf("decoded %+v, want %+v", decoded, snapshot)
+		}
+		if len(decoded.History) != 3 || decoded.History[2].Err.Error() != ErrEventNotExist.Error() ||
+			!decoded.History[0].Time.Equal(snapshot.History[0].Time) {
+			t.Fatalf("unexpected history %+v", decoded.History)
+		}
+		restored := newLinkAuthFSM(t, WithHistory(2))
+		if err := restored.Restore(decoded); err != nil {
+			t.Fatal(err)
+		}
+		if got := restored.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "authenticated"}) {
+			t.Fatalf("unexpected states %v", got)
+		}
+		// only the newest records fit
+		if records := restored.History(); len(records) != 2 || records[0].Event != "login" {
+			t.Fatalf("unexpected history %v", records)
+		}
+	}
+}
+
+func TestRestoreMismatch(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	snapshot := fsm.Snapshot()
+
+	other := newLinkAuthFSM(t)
+	other.AddState("extra")
+	if err := other.Restore(snapshot); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("want ErrSnapshotMismatch, got %v", err)
+	}
+	// final states and timeouts are part of the version
+	other = newLinkAuthFSM(t)
+	other.GetState("down").MarkFinal()
+	if err := other.Restore(snapshot); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("final: want ErrSnapshotMismatch, got %v", err)
+	}
+	other = newLinkAuthFSM(t)
+	other.GetState("up").SetTimeout(time.Second, "disconnect")
+	if err := other.Restore(snapshot); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("timeout: want ErrSnapshotMismatch, got %v", err)
+	}
+
+	snapshot.States["auth"] = "up"
+	if err := newLinkAuthFSM(t).Restore(snapshot); !errors.Is(err, ErrSnapshotMismatch) || !errors.Is(err, ErrStateNotExist) {
+		t.Fatalf("want ErrSnapshotMismatch, got %v", err)
+	}
+	delete(snapshot.States, "auth")
+	if err := newLinkAuthFSM(t).Restore(snapshot); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("want ErrSnapshotMismatch, got %v", err)
+	}
+}
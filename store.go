@@ -0,0 +1,176 @@
+package yafsm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Store persists the transitions of an FSM set up WithStore.
+type Store interface {
+	// Load returns the last saved snapshot, nil if none, and the entries
+	// appended since, oldest first.
+	Load() (*Snapshot, []Entry, error)
+	// Save stores snapshot and may drop the entries it covers, see
+	// Snapshot.Covers. Entries it doesn't cover may be appended before Save
+	// is called and must be kept.
+	Save(snapshot *Snapshot) error
+	// Append stores a committed emission, it must be durable on return.
+	Append(entry Entry) error
+}
+
+// Entry is one committed emission.
+type Entry struct {
+	// Seq numbers the state changes of an FSM in the order they're made,
+	// which may not be the order they're appended in.
+	Seq   uint64        `json:"seq"`
+	Event string        `json:"event"`
+	Prio  int           `json:"prio"`
+	Args  []interface{} `json:"args,omitempty"`
+	Time  time.Time     `json:"time"`
+	// States holds the new state of every region moved, "" being the
+	// default region.
+	States map[string]string `json:"states"`
+	// Fallback marks the move to the panic state after Event panicked, see
+	// WithPanicState, or states set back after Event failed once a snapshot
+	// saw them. It's applied as is rather than replayed.
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// WithStore appends every committed emission to store before answering the
+// emitter. A failing Append is reported wrapped in ErrStore and, with
+// FailureRollback, reverts the transition like a failing handler.
+//
+// Without WithInSeq or WithAsync concurrent emissions may be appended out of
+// order.
+func WithStore(store Store) FSMOption {
+	return func(fsm *FSM) {
+		fsm.store = store
+	}
+}
+
+// persist appends an entry setting states, if any.
+func (fsm *FSM) persist(ec *eventchan, now time.Time, states map[string]string, fallback bool) error {
+	if fsm.store == nil || len(states) == 0 {
+		return nil
+	}
+	entry := Entry{
+		Seq:      ec.seq,
+		Event:    ec.event,
+		Prio:     ec.prio,
+		Args:     ec.args,
+		Time:     now,
+		States:   states,
+		Fallback: fallback,
+	}
+	if err := fsm.store.Append(entry); err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+	return nil
+}
+
+// persistUndone appends an entry setting the states undone back, if a
+// snapshot may have seen them before they were undone.
+func (fsm *FSM) persistUndone(ec *eventchan, now time.Time, undone []*Transition, locked bool) error {
+	states := map[string]string(nil)
+	fsm.critical(locked, func() {
+		if fsm.snapped.Load() < ec.seq {
+			return
+		}
+		states = make(map[string]string, len(undone))
+		for _, tr := range undone {
+			states[tr.Region] = fsm.current(tr.Region)
+		}
+		fsm.seq++
+		ec.seq = fsm.seq
+	})
+	return fsm.persist(ec, now, states, true)
+}
+
+// destinations are the states trs lead to by region.
+func destinations(trs []*Transition) map[string]string {
+	states := make(map[string]string, len(trs))
+	for _, tr := range trs {
+		states[tr.Region] = tr.To
+	}
+	return states
+}
+
+// Checkpoint saves a snapshot of fsm to its store, letting the store drop
+// the entries it covers. Emissions may go on meanwhile: those committed
+// after the snapshot was taken aren't covered and stay in the log, those
+// committed before are covered even if appended later, Recover skips them.
+func (fsm *FSM) Checkpoint() error {
+	if fsm.store == nil {
+		return nil
+	}
+	if err := fsm.store.Save(fsm.Snapshot()); err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+	return nil
+}
+
+// Recover loads the store, restores its snapshot and applies the entries it
+// doesn't cover, without running any hook, or replays them if set up
+// WithEventSourcing. The pending events of the snapshot aren't emitted
+// again, those committed are among the entries. The FSM completes if it ends
+// up in final states.
+func (fsm *FSM) Recover() error {
+	if fsm.store == nil {
+		return nil
+	}
+	snapshot, entries, err := fsm.store.Load()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+	if snapshot != nil {
+		if err := fsm.restore(snapshot); err != nil {
+			return err
+		}
+		uncovered := make([]Entry, 0, len(entries))
+		for _, entry := range entries {
+			if !snapshot.Covers(entry) {
+				uncovered = append(uncovered, entry)
+			}
+		}
+		entries = uncovered
+	}
+	// new entries follow the recovered ones
+	defer func() {
+		fsm.mutex.Lock()
+		for _, entry := range entries {
+			if entry.Seq > fsm.seq {
+				fsm.seq = entry.Seq
+			}
+		}
+		fsm.mutex.Unlock()
+	}()
+
+	if fsm.sourcing {
+		return fsm.Replay(entries, fsm.replayMode)
+	}
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	if fsm.completed && len(entries) != 0 {
+		return ErrFSMDone
+	}
+	for i, entry := range entries {
+		for region, state := range entry.States {
+			st, ok := fsm.states[state]
+			if !ok || st.region != region {
+				return fmt.Errorf("%w: entry %d: region %q: state %q: %w",
+					ErrSnapshotMismatch, i, region, state, ErrStateNotExist)
+			}
+		}
+	}
+	for _, entry := range entries {
+		for region, state := range entry.States {
+			fsm.setCurrent(region, state)
+		}
+	}
+	if len(entries) != 0 && fsm.final() {
+		fsm.completed = true
+		close(fsm.done)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type memStore struct {
+	mutex    sync.Mutex
+	snapshot *Snapshot
+	entries  []Entry
+	err      error
+	// called by Save before it stores the snapshot
+	saving func()
+}
+
+func (s *memStore) Load() (*Snapshot, []Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.snapshot, append([]Entry(nil), s.entries...), nil
+}
+
+func (s *memStore) Save(snapshot *Snapshot) error {
+	if s.saving != nil {
+		s.saving()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries := []Entry{}
+	for _, entry := range s.entries {
+		if !snapshot.Covers(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	s.snapshot, s.entries = snapshot, entries
+	return nil
+}
+
+func (s *memStore) Append(entry Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func TestStoreAppendAndRecover(t *testing.T) {
+	store := &memStore{}
+	fsm := newLinkAuthFSM(t, WithStore(store))
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("missing")
+	if err := fsm.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	fsm.EmitEventWithArgs("login", "alice")
+
+	if len(store.entries) != 1 {
+		t.Fatalf("want 1 entry after checkpoint, got %+v", store.entries)
+	}
+	entry := store.entries[0]
+	if entry.Event != "login" || !reflect.DeepEqual(entry.States, map[string]string{"auth": "authenticated"}) ||
+		!reflect.DeepEqual(entry.Args, []interface{}{"alice"}) {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	recovered := newLinkAuthFSM(t, WithStore(store))
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if got := recovered.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "authenticated"}) {
+		t.Fatalf("unexpected states %v", got)
+	}
+}
+
+func TestStoreAppendFailure(t *testing.T) {
+	errDisk := errors.New("disk full")
+	store := &memStore{err: errDisk}
+
+	fsm := newLinkAuthFSM(t, WithStore(store), WithFailurePolicy(FailureRollback))
+	if err := fsm.EmitEvent("connect"); !errors.Is(err, ErrStore) || !errors.Is(err, errDisk) {
+		t.Fatalf("want ErrStore, got %v", err)
+	}
+	if fsm.State() != "down" {
+		t.Fatalf("transition should be rolled back, in %s", fsm.State())
+	}
+
+	fsm = newLinkAuthFSM(t, WithStore(store))
+	if err := fsm.EmitEvent("connect"); !errors.Is(err, ErrStore) {
+		t.Fatalf("want ErrStore, got %v", err)
+	}
+	if fsm.State() != "up" {
+		t.Fatalf("transition should be kept, in %s", fsm.State())
+	}
+}
+
+func TestRecoverCompletes(t *testing.T) {
+	store := &memStore{}
+	fsm := NewFSM(WithStore(store))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	b.MarkFinal()
+	fsm.AddEvent(evAB, a, b)
+	fsm.EmitEvent(evAB)
+
+	recovered := NewFSM(WithStore(store))
+	a = recovered.Init(stateA)
+	b = recovered.AddState(stateB)
+	b.MarkFinal()
+	recovered.AddEvent(evAB, a, b)
+	if err := recovered.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-recovered.Done():
+	default:
+		t.Fatal("recovered FSM should be complete")
+	}
+}
+
+func TestCheckpointConcurrentEmission(t *testing.T) {
+	for _, sourcing := range []bool{false, true} {
+		opts := func(store Store) []FSMOption {
+			opts := []FSMOption{WithStore(store)}
+			if sourcing {
+				opts = append(opts, WithEventSourcing(ReplaySilent))
+			}
+			return opts
+		}
+		recoverStates := func(store Store) []string {
+			recovered := newLinkAuthFSM(t, opts(store)...)
+			if err := recovered.Recover(); err != nil {
+				t.Fatalf("sourcing %v: %v", sourcing, err)
+			}
+			return recovered.ActiveStates()
+		}
+
+		// committed after the snapshot, appended before Save is done
+		store := &memStore{}
+		fsm := newLinkAuthFSM(t, opts(store)...)
+		fsm.EmitEvent("connect")
+		store.saving = func() { fsm.EmitEvent("login") }
+		if err := fsm.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		if got := recoverStates(store); !reflect.DeepEqual(got, []string{"up", "authenticated"}) {
+			t.Fatalf("sourcing %v: login lost, in %v", sourcing, got)
+		}
+
+		// committed before the snapshot, appended after Save
+		store = &memStore{}
+		fsm = newLinkAuthFSM(t, append(opts(store), WithAsync())...)
+		entered, release := make(chan struct{}), make(chan struct{})
+		fsm.GetState("up").AddEnter(func(*State) {
+			close(entered)
+			<-release
+		})
+		connected := fsm.EmitEventAsync("connect")
+		<-entered
+		if err := fsm.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		close(release)
+		if err := <-connected; err != nil {
+			t.Fatal(err)
+		}
+		fsm.Close()
+		if got := recoverStates(store); !reflect.DeepEqual(got, []string{"up", "anonymous"}) {
+			t.Fatalf("sourcing %v: unexpected states %v", sourcing, got)
+		}
+	}
+}
+
+func TestCheckpointUndoneEmission(t *testing.T) {
+	for _, sourcing := range []bool{false, true} {
+		opts := func(store Store, opts ...FSMOption) []FSMOption {
+			opts = append(opts, WithStore(store))
+			if sourcing {
+				opts = append(opts, WithEventSourcing(ReplaySilent))
+			}
+			return opts
+		}
+
+		// the snapshot sees up, then connect is rolled back
+		store := &memStore{}
+		fsm := newLinkAuthFSM(t, opts(store, WithFailurePolicy(FailureRollback))...)
+		fsm.GetState("up").AddEnterE(func(context.Context, *State) error {
+			if err := fsm.Checkpoint(); err != nil {
+				t.Fatal(err)
+			}
+			return errors.New("boom")
+		})
+		if err := fsm.EmitEvent("connect"); err == nil {
+			t.Fatal("want handler error")
+		}
+		if store.snapshot.States[""] != "up" {
+			t.Fatalf("snapshot should see up, got %v", store.snapshot.States)
+		}
+		recovered := newLinkAuthFSM(t, opts(store)...)
+		if err := recovered.Recover(); err != nil {
+			t.Fatalf("sourcing %v: %v", sourcing, err)
+		}
+		if got := recovered.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "anonymous"}) {
+			t.Fatalf("sourcing %v: recovered %v", sourcing, got)
+		}
+
+		// the snapshot sees both regions moved, the auth one never fires
+		store = &memStore{}
+		fsm = newLinkAuthFSM(t, opts(store)...)
+		fsm.AddEvent("boot", fsm.GetState("down"), fsm.GetState("up"))
+		fsm.AddEvent("boot", fsm.GetState("anonymous"), fsm.GetState("authenticated"))
+		fsm.GetState("up").AddEnterE(func(context.Context, *State) error {
+			fsm.Checkpoint()
+			return errors.New("boom")
+		})
+		fsm.EmitEvent("boot")
+		if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "anonymous"}) {
+			t.Fatalf("unexpected states %v", got)
+		}
+		recovered = newLinkAuthFSM(t, opts(store)...)
+		recovered.AddEvent("boot", recovered.GetState("down"), recovered.GetState("up"))
+		recovered.AddEvent("boot", recovered.GetState("anonymous"), recovered.GetState("authenticated"))
+		if err := recovered.Recover(); err != nil {
+			t.Fatalf("sourcing %v: %v", sourcing, err)
+		}
+		if got := recovered.ActiveStates(); !reflect.DeepEqual(got, []string{"up", "anonymous"}) {
+			t.Fatalf("sourcing %v: recovered %v", sourcing, got)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package yafsm
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time of an FSM: timers, history and log entry
+// timestamps and the times reported to listeners. AfterFunc calls f once d
+// elapsed, never before returning, stop prevents that and reports whether it
+// did. yafsmtest.Clock is a fake one for tests.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// WithClock sets the clock of the FSM, the wall clock by default.
+func WithClock(clock Clock) FSMOption {
+	return func(fsm *FSM) {
+		fsm.clock = clock
+	}
+}
+
+const (
+	timerPending int32 = iota
+	timerFired
+	timerCancelled
+)
+
+// Timer is an event scheduled by EmitEventAfter, EmitEventAt or a state
+// timeout.
+type Timer struct {
+	fsm   *FSM
+	event string
+	args  []interface{}
+	// the state the timeout belongs to, nil for scheduled events
+	st     *State
+	state  int32
+	stop   func() bool
+	result chan error
+}
+
+// Cancel prevents the event from being emitted and returns true unless it
+// already was or the timer was cancelled before.
+func (t *Timer) Cancel() bool {
+	t.fsm.mutex.Lock()
+	defer t.fsm.mutex.Unlock()
+	return t.cancel()
+}
+
+// Result delivers the outcome of the emission once the timer fired, or
+// ErrTimerCancelled.
+func (t *Timer) Result() <-chan error {
+	return t.result
+}
+
+// cancel is Cancel with fsm.mutex held.
+func (t *Timer) cancel() bool {
+	if !atomic.CompareAndSwapInt32(&t.state, timerPending, timerCancelled) {
+		return false
+	}
+	t.stop()
+	delete(t.fsm.timers, t)
+	if t.st != nil && t.fsm.timeouts[t.st] == t {
+		delete(t.fsm.timeouts, t.st)
+	}
+	t.result <- ErrTimerCancelled
+	return true
+}
+
+func (t *Timer) fire() {
+	if !atomic.CompareAndSwapInt32(&t.state, timerPending, timerFired) {
+		return
+	}
+	t.fsm.mutex.Lock()
+	delete(t.fsm.timers, t)
+	t.fsm.mutex.Unlock()
+	if t.st == nil {
+		t.result <- <-t.fsm.push(context.Background(), 1, t.event, t.args)
+		return
+	}
+	// a state timeout only transitions if the state wasn't left meanwhile
+	ec := &eventchan{
+		ctx:   context.Background(),
+		event: t.event,
+		prio:  1,
+		ch:    make(chan error, 1),
+		timer: t,
+		em: &Emission{
+			Event:  t.event,
+			Prio:   1,
+			Mode:   t.fsm.mode(),
+			Queued: t.fsm.clock.Now(),
+		},
+	}
+	t.result <- <-t.fsm.enqueue(ec)
+}
+
+// EmitEventAfter emits event with args once d elapsed, unless the returned
+// timer is cancelled or the FSM closed first.
+func (fsm *FSM) EmitEventAfter(d time.Duration, event string, args ...interface{}) *Timer {
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+	return fsm.schedule(d, event, args, nil)
+}
+
+// EmitEventAt is EmitEventAfter at t.
+func (fsm *FSM) EmitEventAt(t time.Time, event string, args ...interface{}) *Timer {
+	return fsm.EmitEventAfter(t.Sub(fsm.clock.Now()), event, args...)
+}
+
+// schedule starts a timer, fsm.mutex must be held.
+func (fsm *FSM) schedule(d time.Duration, event string, args []interface{}, st *State) *Timer {
+	t := &Timer{
+		fsm:    fsm,
+		event:  event,
+		args:   args,
+		st:     st,
+		result: make(chan error, 1),
+	}
+	if fsm.closed {
+		t.state = timerFired
+		t.result <- ErrFSMClosed
+		return t
+	}
+	fsm.timers[t] = struct{}{}
+	t.stop = fsm.clock.AfterFunc(d, t.fire)
+	return t
+}
+
+// SetTimeout makes entering st schedule event after d, the timer is cancelled
+// when st is left. It's restarted by a transition to st itself but not by an
+// internal one, nor by Init, SetState or Restore. A zero d removes it.
+func (st *State) SetTimeout(d time.Duration, event string) {
+	st.timeout, st.timeoutEvent = d, event
+}
+
+// Timeout returns the timeout set by SetTimeout.
+func (st *State) Timeout() (time.Duration, string) {
+	return st.timeout, st.timeoutEvent
+}
+
+// rearm cancels the timeouts of the states trs left and starts the ones of
+// the states entered, fsm.mutex must be held.
+func (fsm *FSM) rearm(trs []*Transition) {
+	for _, tr := range trs {
+		for _, st := range tr.exits {
+			if t, ok := fsm.timeouts[st]; ok {
+				t.cancel()
+				delete(fsm.timeouts, st)
+			}
+		}
+	}
+	for _, tr := range trs {
+		for _, st := range tr.enters {
+			if st.timeout > 0 {
+				fsm.timeouts[st] = fsm.schedule(st.timeout, st.timeoutEvent, nil, st)
+			}
+		}
+	}
+}
+
+// armed reports whether t is the timeout of the current stay in its state,
+// fsm.mutex must be held.
+func (fsm *FSM) armed(t *Timer) bool {
+	if fsm.timeouts[t.st] != t {
+		return false
+	}
+	// SetState may have moved away without leaving
+	for st := fsm.states[fsm.current(t.st.region)]; st != nil; st = st.parent {
+		if st == t.st {
+			return true
+		}
+	}
+	return false
+}
+
+// stopTimers cancels every pending timer, fsm.mutex must be held.
+func (fsm *FSM) stopTimers() {
+	for t := range fsm.timers {
+		t.cancel()
+	}
+}
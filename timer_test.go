@@ -0,0 +1,160 @@
+package yafsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/singchia/yafsm/yafsmtest"
+)
+
+func TestEmitEventAfter(t *testing.T) {
+	clock := yafsmtest.NewClock(time.Unix(0, 0))
+	fsm := newLinkAuthFSM(t, WithClock(clock))
+	timer := fsm.EmitEventAfter(time.Second, "connect")
+	clock.Advance(time.Second / 2)
+	if fsm.State() != "down" {
+		t.Fatal("timer fired too early")
+	}
+	clock.Advance(time.Second / 2)
+	if err := <-timer.Result(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.State() != "up" {
+		t.Fatalf("want up, got %s", fsm.State())
+	}
+	if timer.Cancel() {
+		t.Fatal("a fired timer can't be cancelled")
+	}
+
+	timer = fsm.EmitEventAt(clock.Now().Add(time.Minute), "disconnect")
+	clock.Advance(time.Minute)
+	if err := <-timer.Result(); err != nil || fsm.State() != "down" {
+		t.Fatalf("want down, got %s %v", fsm.State(), err)
+	}
+}
+
+func TestTimerCancel(t *testing.T) {
+	clock := yafsmtest.NewClock(time.Unix(0, 0))
+	fsm := newLinkAuthFSM(t, WithClock(clock))
+	timer := fsm.EmitEventAfter(time.Second, "connect")
+	if !timer.Cancel() || timer.Cancel() {
+		t.Fatal("only the first Cancel should cancel")
+	}
+	if err := <-timer.Result(); !errors.Is(err, ErrTimerCancelled) {
+		t.Fatalf("want ErrTimerCancelled, got %v", err)
+	}
+	clock.Advance(time.Second)
+	if fsm.State() != "down" {
+		t.Fatal("cancelled timer fired")
+	}
+}
+
+func TestStateTimeout(t *testing.T) {
+	for _, opts := range [][]FSMOption{nil, {WithInSeq()}, {WithAsync()}} {
+		clock := yafsmtest.NewClock(time.Unix(0, 0))
+		fsm := newLinkAuthFSM(t, append(opts, WithClock(clock))...)
+		fsm.GetState("up").SetTimeout(time.Second, "disconnect")
+
+		// times out
+		if err := fsm.EmitEvent("connect"); err != nil {
+			t.Fatal(err)
+		}
+		timer := fsm.timeouts[fsm.GetState("up")]
+		clock.Advance(time.Second)
+		if err := <-timer.Result(); err != nil || fsm.State() != "down" {
+			t.Fatalf("want down, got %s %v", fsm.State(), err)
+		}
+
+		// cancelled on leave
+		fsm.EmitEvent("connect")
+		timer = fsm.timeouts[fsm.GetState("up")]
+		fsm.EmitEvent("disconnect")
+		if err := <-timer.Result(); !errors.Is(err, ErrTimerCancelled) {
+			t.Fatalf("want ErrTimerCancelled, got %v", err)
+		}
+		if len(fsm.timers) != 0 || len(fsm.timeouts) != 0 {
+			t.Fatal("timers left behind")
+		}
+		fsm.Close()
+	}
+}
+
+func TestStateTimeoutStale(t *testing.T) {
+	clock := yafsmtest.NewClock(time.Unix(0, 0))
+	fsm := newLinkAuthFSM(t, WithClock(clock))
+	down := fsm.GetState("down")
+	fsm.GetState("up").SetTimeout(time.Second, "disconnect")
+	fsm.AddEvent("reset", down, down)
+	down.SetTimeout(time.Second, "connect")
+
+	fsm.EmitEvent("connect")
+	timer := fsm.timeouts[fsm.GetState("up")]
+	// moved away without leaving, the timeout no longer applies
+	fsm.SetState("down")
+	clock.Advance(time.Second)
+	if err := <-timer.Result(); !errors.Is(err, ErrTimerCancelled) {
+		t.Fatalf("want ErrTimerCancelled, got %v", err)
+	}
+
+	// a self transition restarts the timeout
+	fsm.EmitEvent("reset")
+	clock.Advance(time.Second / 2)
+	fsm.EmitEvent("reset")
+	clock.Advance(time.Second / 2)
+	if fsm.State() != "down" {
+		t.Fatal("timeout not restarted")
+	}
+	clock.Advance(time.Second / 2)
+	if fsm.State() != "up" {
+		t.Fatalf("want up, got %s", fsm.State())
+	}
+}
+
+func TestCloseCancelsTimers(t *testing.T) {
+	clock := yafsmtest.NewClock(time.Unix(0, 0))
+	fsm := newLinkAuthFSM(t, WithClock(clock))
+	timer := fsm.EmitEventAfter(time.Second, "connect")
+	fsm.Close()
+	if err := <-timer.Result(); !errors.Is(err, ErrTimerCancelled) {
+		t.Fatalf("want ErrTimerCancelled, got %v", err)
+	}
+	if err := <-fsm.EmitEventAfter(time.Second, "connect").Result(); !errors.Is(err, ErrFSMClosed) {
+		t.Fatalf("want ErrFSMClosed, got %v", err)
+	}
+}
+
+func TestTimerWallClock(t *testing.T) {
+	fsm := newLinkAuthFSM(t, WithAsync())
+	defer fsm.Close()
+	if err := <-fsm.EmitEventAfter(time.Millisecond, "connect").Result(); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.State() != "up" {
+		t.Fatalf("want up, got %s", fsm.State())
+	}
+}
+
+func TestClockTimestamps(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := yafsmtest.NewClock(start)
+	fsm := newLinkAuthFSM(t, WithClock(clock), WithHistory(4))
+	// the enter hook takes a second
+	fsm.GetState("up").AddEnter(func(*State) { clock.Advance(time.Second) })
+	got, steps := (*Emission)(nil), []*Step{}
+	fsm.AddListener(listenerFuncs{
+		after: func(em *Emission) { got = em },
+		step:  func(step *Step) { steps = append(steps, step) },
+	})
+	fsm.EmitEvent("connect")
+
+	if !got.Queued.Equal(start) || !got.Started.Equal(start) || !got.Ended.Equal(start.Add(time.Second)) {
+		t.Fatalf("unexpected emission times %+v", got)
+	}
+	if enter := steps[len(steps)-1]; !enter.Start.Equal(start) || enter.Duration != time.Second {
+		t.Fatalf("unexpected step %+v", enter)
+	}
+	if r := fsm.History()[0]; !r.Time.Equal(start) {
+		t.Fatalf("unexpected record time %v", r.Time)
+	}
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RecordedSpan is a span kept by a Recorder.
+type RecordedSpan struct {
+	Name               string
+	Parent             *RecordedSpan
+	StartTime, EndTime time.Time
+	Attributes         map[string]interface{}
+	Errors             []error
+	Ended              bool
+
+	recorder *Recorder
+}
+
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	for _, attr := range attrs {
+		s.Attributes[attr.Key] = attr.Value
+	}
+}
+
+func (s *RecordedSpan) RecordError(err error) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	s.Errors = append(s.Errors, err)
+}
+
+func (s *RecordedSpan) End(end time.Time) {
+	s.recorder.mutex.Lock()
+	defer s.recorder.mutex.Unlock()
+	s.EndTime, s.Ended = end, true
+}
+
+type spanKey struct{}
+
+// Recorder is an in-memory Tracer, spans are parented through the context
+// like in OpenTelemetry.
+type Recorder struct {
+	mutex sync.Mutex
+	spans []*RecordedSpan
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*RecordedSpan)
+	span := &RecordedSpan{
+		Name:       name,
+		Parent:     parent,
+		StartTime:  start,
+		Attributes: make(map[string]interface{}, len(attrs)),
+		recorder:   r,
+	}
+	for _, attr := range attrs {
+		span.Attributes[attr.Key] = attr.Value
+	}
+	r.mutex.Lock()
+	r.spans = append(r.spans, span)
+	r.mutex.Unlock()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Spans returns the spans started so far, in order.
+func (r *Recorder) Spans() []*RecordedSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*RecordedSpan(nil), r.spans...)
+}
+
+// ContextWithSpan returns ctx carrying span as parent of the spans started
+// from it, to stand in for the caller's own span.
+func ContextWithSpan(ctx context.Context, span *RecordedSpan) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
@@ -0,0 +1,152 @@
+// Package tracing opens a span for every yafsm emission, with a child span
+// for the handlers of every state left, event and state entered. It's defined
+// against the small Tracer interface, which an OpenTelemetry trace.Tracer is
+// easily adapted to, and ships the in-memory Recorder for tests.
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/singchia/yafsm"
+)
+
+const (
+	AttrEvent     = "yafsm.event"
+	AttrPrio      = "yafsm.prio"
+	AttrMode      = "yafsm.mode"
+	AttrQueueWait = "yafsm.queue_wait"
+	// AttrFrom and AttrTo are comma separated if several regions moved.
+	AttrFrom   = "yafsm.from"
+	AttrTo     = "yafsm.to"
+	AttrRegion = "yafsm.region"
+	AttrState  = "yafsm.state"
+)
+
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts spans at a given time, as the handler spans are only known
+// once the handlers ran.
+type Tracer interface {
+	Start(ctx context.Context, name string, start time.Time, attrs ...Attribute) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End(end time.Time)
+}
+
+type emission struct {
+	ctx  context.Context
+	span Span
+}
+
+// Listener is a yafsm.Listener tracing emissions. The emission span starts
+// when the event is emitted and is a child of the span in the context given
+// at emission time, if any. Handlers don't see the emission span in their
+// context.
+type Listener struct {
+	yafsm.NopListener
+
+	tracer    Tracer
+	mutex     sync.Mutex
+	emissions map[*yafsm.Emission]*emission
+}
+
+func New(tracer Tracer) *Listener {
+	return &Listener{
+		tracer:    tracer,
+		emissions: make(map[*yafsm.Emission]*emission),
+	}
+}
+
+func (l *Listener) BeforeEvent(ctx context.Context, em *yafsm.Emission) {
+	ctx, span := l.tracer.Start(ctx, "emit "+em.Event, em.Queued,
+		Attribute{AttrEvent, em.Event},
+		Attribute{AttrPrio, em.Prio},
+		Attribute{AttrMode, em.Mode},
+		Attribute{AttrQueueWait, em.Started.Sub(em.Queued)})
+	l.mutex.Lock()
+	l.emissions[em] = &emission{ctx, span}
+	l.mutex.Unlock()
+}
+
+// Rejected ends a span right away for emissions rejected before being
+// queued, the others end in AfterEvent.
+func (l *Listener) Rejected(ctx context.Context, em *yafsm.Emission, err error) {
+	l.mutex.Lock()
+	_, ok := l.emissions[em]
+	l.mutex.Unlock()
+	if ok {
+		return
+	}
+	_, span := l.tracer.Start(ctx, "emit "+em.Event, em.Queued,
+		Attribute{AttrEvent, em.Event},
+		Attribute{AttrPrio, em.Prio},
+		Attribute{AttrMode, em.Mode})
+	span.RecordError(err)
+	span.End(em.Queued)
+}
+
+func (l *Listener) Leave(ctx context.Context, em *yafsm.Emission, step *yafsm.Step) {
+	l.step(em, step)
+}
+
+func (l *Listener) Transition(ctx context.Context, em *yafsm.Emission, step *yafsm.Step) {
+	l.step(em, step)
+}
+
+func (l *Listener) Enter(ctx context.Context, em *yafsm.Emission, step *yafsm.Step) {
+	l.step(em, step)
+}
+
+func (l *Listener) step(em *yafsm.Emission, step *yafsm.Step) {
+	l.mutex.Lock()
+	e, ok := l.emissions[em]
+	l.mutex.Unlock()
+	if !ok {
+		return
+	}
+	attrs := []Attribute{{AttrRegion, step.Transition.Region}}
+	if step.Phase == yafsm.PhaseEvent {
+		attrs = append(attrs, Attribute{AttrEvent, step.Name})
+	} else {
+		attrs = append(attrs, Attribute{AttrState, step.Name})
+	}
+	_, span := l.tracer.Start(e.ctx, string(step.Phase)+" "+step.Name, step.Start, attrs...)
+	if step.Err != nil {
+		span.RecordError(step.Err)
+	}
+	span.End(step.Start.Add(step.Duration))
+}
+
+func (l *Listener) AfterEvent(ctx context.Context, em *yafsm.Emission, err error) {
+	l.mutex.Lock()
+	e, ok := l.emissions[em]
+	delete(l.emissions, em)
+	l.mutex.Unlock()
+	if !ok {
+		return
+	}
+	if len(em.Transitions) != 0 {
+		froms := make([]string, 0, len(em.Transitions))
+		tos := make([]string, 0, len(em.Transitions))
+		for _, tr := range em.Transitions {
+			froms = append(froms, tr.From)
+			tos = append(tos, tr.To)
+		}
+		e.span.SetAttributes(
+			Attribute{AttrFrom, strings.Join(froms, ",")},
+			Attribute{AttrTo, strings.Join(tos, ",")})
+	}
+	if err != nil {
+		e.span.RecordError(err)
+	}
+	e.span.End(em.Ended)
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/singchia/yafsm"
+)
+
+func newFSM(t *testing.T, recorder *Recorder, opts ...yafsm.FSMOption) *yafsm.FSM {
+	fsm := yafsm.NewFSM(opts...)
+	idle := fsm.Init("idle")
+	running := fsm.AddState("running")
+	if _, err := fsm.AddEvent("start", idle, running); err != nil {
+		t.Fatal(err)
+	}
+	fsm.AddListener(New(recorder))
+	return fsm
+}
+
+func TestSpans(t *testing.T) {
+	for _, mode := range []string{"sync", "inseq", "async"} {
+		t.Run(mode, func(t *testing.T) {
+			opts := map[string][]yafsm.FSMOption{
+				"inseq": {yafsm.WithInSeq()},
+				"async": {yafsm.WithAsync()},
+			}[mode]
+			recorder := NewRecorder()
+			fsm := newFSM(t, recorder, opts...)
+			defer fsm.Close()
+			errEnter := errors.New("no")
+			fsm.GetState("running").AddEnterE(func(context.Context, *yafsm.State) error { return errEnter })
+
+			_, root := recorder.Start(context.Background(), "request", time.Now())
+			ctx := ContextWithSpan(context.Background(), root.(*RecordedSpan))
+			if err := fsm.EmitPrioEventContext(ctx, 2, "start"); !errors.Is(err, errEnter) {
+				t.Fatalf("want handler error, got %v", err)
+			}
+
+			spans := recorder.Spans()
+			if len(spans) != 5 {
+				t.Fatalf("want 5 spans, got %d", len(spans))
+			}
+			emit := spans[1]
+			if emit.Name != "emit start" || emit.Parent != root || !emit.Ended {
+				t.Fatalf("unexpected emission span %+v", emit)
+			}
+			for key, want := range map[string]interface{}{
+				AttrEvent: "start", AttrPrio: 2, AttrMode: mode, AttrFrom: "idle", AttrTo: "running",
+			} {
+				if got := emit.Attributes[key]; got != want {
+					t.Errorf("%s: want %v, got %v", key, want, got)
+				}
+			}
+			if wait, ok := emit.Attributes[AttrQueueWait].(time.Duration); !ok || wait < 0 {
+				t.Errorf("unexpected queue wait %v", emit.Attributes[AttrQueueWait])
+			}
+			if len(emit.Errors) != 1 || !errors.Is(emit.Errors[0], errEnter) {
+				t.Errorf("unexpected errors %v", emit.Errors)
+			}
+			for i, name := range []string{"leave idle", "event start", "enter running"} {
+				span := spans[i+2]
+				if span.Name != name || span.Parent != emit || !span.Ended || span.EndTime.Before(span.StartTime) {
+					t.Errorf("unexpected span %+v", span)
+				}
+			}
+			if len(spans[4].Errors) != 1 {
+				t.Errorf("enter span should hold the error")
+			}
+		})
+	}
+}
+
+func TestRejectedSpan(t *testing.T) {
+	recorder := NewRecorder()
+	fsm := newFSM(t, recorder)
+	fsm.EmitEvent("missing")
+	fsm.EmitEvent("start")
+	fsm.EmitEvent("start")
+
+	spans := recorder.Spans()
+	if len(spans) != 6 {
+		t.Fatalf("want 6 spans, got %d", len(spans))
+	}
+	if span := spans[0]; span.Name != "emit missing" || !span.Ended ||
+		len(span.Errors) != 1 || !errors.Is(span.Errors[0], yafsm.ErrEventNotExist) {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if span := spans[5]; span.Name != "emit start" || !span.Ended ||
+		len(span.Errors) != 1 || !errors.Is(span.Errors[0], yafsm.ErrIllegalStateForEvent) {
+		t.Fatalf("unexpected span %+v", span)
+	}
+}
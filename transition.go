@@ -0,0 +1,149 @@
+package yafsm
+
+import (
+	"context"
+	"time"
+)
+
+// Transition describes a single emission while it is being handled, it is
+// reachable from the context given to guards and error-returning handlers.
+// From is the state the FSM was in, which may be a descendant of the event's
+// From when the event is inherited from a parent state.
+type Transition struct {
+	Event    string
+	Region   string
+	From, To string
+	Args     []interface{}
+
+	event           *Event
+	ctx             context.Context
+	clock           Clock
+	exits, enters   []*State
+	exited, entered int
+	// set once fire is called
+	started bool
+}
+
+type transitionKey struct{}
+
+// newTransition builds the transition of et starting from the current leaf
+// state cur, which is et.From or one of its descendants.
+func newTransition(parent context.Context, region string, cur *State, et *Event, ec *eventchan, clock Clock) *Transition {
+	tr := &Transition{
+		Event:  et.Event,
+		Region: region,
+		From:   cur.State,
+		To:     et.To.State,
+		Args:   ec.args,
+		event:  et,
+		clock:  clock,
+	}
+	if et.internal {
+		// stay in the current leaf, which may be a descendant of et.To
+		tr.To = cur.State
+	} else {
+		tr.exits, tr.enters = path(cur, et.To)
+	}
+	tr.ctx = context.WithValue(parent, transitionKey{}, tr)
+	return tr
+}
+
+// fire runs the leave, event and enter handlers in order and stops at the
+// first failing or panicking one. step, if not nil, is told about every
+// state left or entered and the event handlers once they ran.
+func (tr *Transition) fire(step stepFunc) (err error) {
+	var (
+		// the handler running, for panics
+		phase Phase
+		name  string
+		index int
+		start time.Time
+	)
+	defer func() {
+		if p := recover(); p != nil {
+			err = &HandlerError{Phase: phase, Name: name, Index: index, Err: newPanicError(p)}
+			step.done(tr, phase, name, start, err)
+		}
+	}()
+
+	tr.started = true
+	et := tr.event
+	phase = PhaseLeave
+	for _, st := range tr.exits {
+		tr.exited++
+		name, start = st.State, tr.now(step)
+		for i, left := range st.lefts {
+			index = i
+			if err := left(tr.ctx, st); err != nil {
+				err = &HandlerError{Phase: PhaseLeave, Name: st.State, Index: i, Err: err}
+				step.done(tr, PhaseLeave, st.State, start, err)
+				return err
+			}
+		}
+		step.done(tr, PhaseLeave, st.State, start, nil)
+	}
+	phase, name, start = PhaseEvent, et.Event, tr.now(step)
+	for i, handler := range et.handlers {
+		index = i
+		if err := handler(tr.ctx, et); err != nil {
+			err = &HandlerError{Phase: PhaseEvent, Name: et.Event, Index: i, Err: err}
+			step.done(tr, PhaseEvent, et.Event, start, err)
+			return err
+		}
+	}
+	step.done(tr, PhaseEvent, et.Event, start, nil)
+	phase = PhaseEnter
+	for _, st := range tr.enters {
+		tr.entered++
+		name, start = st.State, tr.now(step)
+		for i, enter := range st.enters {
+			index = i
+			if err := enter(tr.ctx, st); err != nil {
+				err = &HandlerError{Phase: PhaseEnter, Name: st.State, Index: i, Err: err}
+				step.done(tr, PhaseEnter, st.State, start, err)
+				return err
+			}
+		}
+		step.done(tr, PhaseEnter, st.State, start, nil)
+	}
+	return nil
+}
+
+// compensate leaves the states entered so far and re-enters the ones left.
+func (tr *Transition) compensate() []error {
+	errs := []error{}
+	for i := tr.entered - 1; i >= 0; i-- {
+		st := tr.enters[i]
+		for _, left := range st.lefts {
+			if err := tr.call(left, st); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for i := tr.exited - 1; i >= 0; i-- {
+		st := tr.exits[i]
+		for _, enter := range st.enters {
+			if err := tr.call(enter, st); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// call runs a compensating hook, a panic is returned as PanicError.
+func (tr *Transition) call(handler StateHandlerE, st *State) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = newPanicError(p)
+		}
+	}()
+	return handler(tr.ctx, st)
+}
+
+// TransitionFromContext returns the transition being handled, or nil if ctx
+// does not belong to one.
+func TransitionFromContext(ctx context.Context) *Transition {
+	tr, _ := ctx.Value(transitionKey{}).(*Transition)
+	return tr
+}
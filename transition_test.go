@@ -0,0 +1,164 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmitEventWithArgs(t *testing.T) {
+	fsm := newAB()
+	a := fsm.GetState(stateA)
+	b := fsm.GetState(stateB)
+	et := fsm.GetEvent(evAB, a, b)
+
+	got := map[string]interface{}{}
+	record := func(name string, ctx context.Context) {
+		tr := TransitionFromContext(ctx)
+		if tr == nil || len(tr.Args) != 2 {
+			t.Errorf("%s: unexpected transition %+v", name, tr)
+			return
+		}
+		if tr.Event != evAB || tr.From != stateA || tr.To != stateB {
+			t.Errorf("%s: unexpected transition %+v", name, tr)
+		}
+		got[name] = tr.Args[0]
+	}
+	et.AddGuard(func(ctx context.Context, et *Event) error { record("guard", ctx); return nil })
+	a.AddLeftE(func(ctx context.Context, st *State) error { record("leave", ctx); return nil })
+	et.AddHandlerE(func(ctx context.Context, et *Event) error { record("event", ctx); return nil })
+	b.AddEnterE(func(ctx context.Context, st *State) error { record("enter", ctx); return nil })
+
+	if err := fsm.EmitEventWithArgs(evAB, "packet", 42); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"guard", "leave", "event", "enter"} {
+		if got[name] != "packet" {
+			t.Fatalf("%s did not receive args: %v", name, got)
+		}
+	}
+}
+
+func TestEmitAsyncAndPrioWithArgs(t *testing.T) {
+	fsm := NewFSM(WithAsync())
+	defer fsm.Close()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	ab, _ := fsm.AddEvent(evAB, a, b)
+	ba, _ := fsm.AddEvent("b->a", b, a)
+
+	args := make(chan interface{}, 2)
+	handler := func(ctx context.Context, et *Event) error {
+		args <- TransitionFromContext(ctx).Args[0]
+		return nil
+	}
+	ab.AddHandlerE(handler)
+	ba.AddHandlerE(handler)
+
+	if err := <-fsm.EmitEventAsyncWithArgs(evAB, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitPrioEventWithArgs(2, "b->a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if v1, v2 := <-args, <-args; v1 != 1 || v2 != 2 {
+		t.Fatalf("got args %v %v", v1, v2)
+	}
+	if err := <-fsm.EmitPrioEventAsyncWithArgs(2, evAB, 3); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-args; v != 3 {
+		t.Fatalf("got arg %v", v)
+	}
+}
+
+func TestTransitionFromContextMissing(t *testing.T) {
+	if tr := TransitionFromContext(context.Background()); tr != nil {
+		t.Fatalf("want nil, got %+v", tr)
+	}
+}
+
+func TestEmitEventContextCancelledWhileQueued(t *testing.T) {
+	fsm := NewFSM(WithAsync())
+	defer fsm.Close()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	c := fsm.AddState(stateC)
+	ab, _ := fsm.AddEvent(evAB, a, b)
+	if _, err := fsm.AddEvent(evBC, b, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the dispatcher inside a->b
+	release := make(chan struct{})
+	ab.AddHandler(func(*Event) { <-release })
+	first := fsm.EmitEventAsync(evAB)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- fsm.EmitEventContext(ctx, evBC) }()
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	// the skipped b->c must not run once the dispatcher gets to it
+	if err := fsm.EmitEvent(evAB); !errors.Is(err, ErrIllegalStateForEvent) {
+		t.Fatalf("want ErrIllegalStateForEvent, got %v", err)
+	}
+	if fsm.State() != stateB {
+		t.Fatalf("expected B, got %q", fsm.State())
+	}
+}
+
+func TestEmitEventContextDeadlineAsync(t *testing.T) {
+	fsm := NewFSM(WithAsync())
+	defer fsm.Close()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	ab, _ := fsm.AddEvent(evAB, a, b)
+	release := make(chan struct{})
+	ab.AddHandler(func(*Event) { <-release })
+	first := fsm.EmitEventAsync(evAB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := <-fsm.EmitPrioEventAsyncContext(ctx, 3, "b->a"); !errors.Is(err, ErrEventNotExist) {
+		t.Fatalf("want ErrEventNotExist, got %v", err)
+	}
+	if err := <-fsm.EmitEventAsyncContext(ctx, evAB); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmitEventContextPassedToHandlers(t *testing.T) {
+	fsm := newAB()
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "req-1")
+	et := fsm.GetEvents(evAB)[0]
+	got := ""
+	et.AddHandlerE(func(ctx context.Context, et *Event) error {
+		got, _ = ctx.Value(key{}).(string)
+		return nil
+	})
+	if err := fsm.EmitPrioEventContext(ctx, 2, evAB); err != nil {
+		t.Fatal(err)
+	}
+	if got != "req-1" {
+		t.Fatalf("handler ctx value = %q", got)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fsm.EmitEventContext(cancelled, evAB); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+}
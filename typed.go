@@ -0,0 +1,366 @@
+package yafsm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Machine is a type-safe FSM whose states are values of S, events values of
+// E and whose handlers receive the context value c of type C, typically the
+// object the FSM belongs to such as a connection. It's built on an FSM, see
+// FSM, with S and E values named by fmt.Sprint, e.g. their String method.
+type Machine[S, E comparable, C any] struct {
+	fsm *FSM
+	c   C
+
+	mutex  sync.RWMutex
+	states map[S]*State
+	// reverse lookups of the names
+	stateNames map[string]S
+	eventNames map[string]E
+	// run by the underlying events of every registration
+	handlers map[E][]EventFunc[S, E, C]
+	guards   map[E][]EventFunc[S, E, C]
+}
+
+// StateFunc is a typed enter or leave hook, a non-nil error aborts the
+// transition like a StateHandlerE's.
+type StateFunc[S comparable, C any] func(ctx context.Context, state S, c C) error
+
+// EventFunc is a typed event handler or guard.
+type EventFunc[S, E comparable, C any] func(ctx context.Context, tr TransitionOf[S, E], c C) error
+
+// TransitionOf is the typed view of a Transition.
+type TransitionOf[S, E comparable] struct {
+	Event    E
+	Region   string
+	From, To S
+	Args     []interface{}
+}
+
+// New returns a Machine in initial, handing c to its handlers. opts are
+// those of NewFSM, the emission modes included.
+func New[S, E comparable, C any](initial S, c C, opts ...FSMOption) *Machine[S, E, C] {
+	m := &Machine[S, E, C]{
+		fsm:        NewFSM(opts...),
+		c:          c,
+		states:     make(map[S]*State),
+		stateNames: make(map[string]S),
+		eventNames: make(map[string]E),
+		handlers:   make(map[E][]EventFunc[S, E, C]),
+		guards:     make(map[E][]EventFunc[S, E, C]),
+	}
+	name := fmt.Sprint(initial)
+	m.states[initial] = m.fsm.Init(name)
+	m.stateNames[name] = initial
+	return m
+}
+
+// FSM returns the underlying FSM, e.g. for listeners, persistence or
+// definitions, where states and events go by name.
+func (m *Machine[S, E, C]) FSM() *FSM {
+	return m.fsm
+}
+
+// Context returns the value handed to the handlers.
+func (m *Machine[S, E, C]) Context() C {
+	return m.c
+}
+
+// AddState adds state, ErrStateDuplicated means another state has the same
+// name.
+func (m *Machine[S, E, C]) AddState(state S) error {
+	return m.addState(state, func(name string) (*State, error) {
+		return m.fsm.AddState(name), nil
+	})
+}
+
+// AddSubState adds state nested in parent, see FSM.AddSubState.
+func (m *Machine[S, E, C]) AddSubState(parent, state S) error {
+	return m.addState(state, func(name string) (*State, error) {
+		p, err := m.lookup(parent)
+		if err != nil {
+			return nil, err
+		}
+		return m.fsm.AddSubState(p, name)
+	})
+}
+
+// addState registers state with add. Guards and hooks take m.mutex while
+// the FSM is locked, so m.mutex is never held while calling into the FSM.
+func (m *Machine[S, E, C]) addState(state S, add func(name string) (*State, error)) error {
+	name := fmt.Sprint(state)
+	if err := checkName(&m.mutex, name, state, m.stateNames, ErrStateDuplicated); err != nil {
+		return err
+	}
+	st, err := add(name)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.states[state] = st
+	m.stateNames[name] = state
+	return nil
+}
+
+// checkName returns dup if name is taken by another value than v.
+func checkName[V comparable](mutex *sync.RWMutex, name string, v V, names map[string]V, dup error) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if u, ok := names[name]; ok && u != v {
+		return fmt.Errorf("%w: %q", dup, name)
+	}
+	return nil
+}
+
+// lookup returns the State of s.
+func (m *Machine[S, E, C]) lookup(s S) (*State, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	st, ok := m.states[s]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrStateNotExist, s)
+	}
+	return st, nil
+}
+
+// AddEvent registers event from from to to, see FSM.AddEvent.
+func (m *Machine[S, E, C]) AddEvent(event E, from, to S) error {
+	return m.AddEventFrom(event, []S{from}, to)
+}
+
+// AddEventFrom registers event from each of froms to to, see
+// FSM.AddEventFrom.
+func (m *Machine[S, E, C]) AddEventFrom(event E, froms []S, to S) error {
+	return m.addEvent(event, func(name string) ([]*Event, error) {
+		sts := make([]*State, 0, len(froms))
+		for _, from := range froms {
+			st, err := m.lookup(from)
+			if err != nil {
+				return nil, err
+			}
+			sts = append(sts, st)
+		}
+		st, err := m.lookup(to)
+		if err != nil {
+			return nil, err
+		}
+		return m.fsm.AddEventFrom(name, sts, st)
+	})
+}
+
+// AddEventFromAny registers event from every state of to's region, see
+// FSM.AddEventFromAny.
+func (m *Machine[S, E, C]) AddEventFromAny(event E, to S) error {
+	return m.addEvent(event, func(name string) ([]*Event, error) {
+		st, err := m.lookup(to)
+		if err != nil {
+			return nil, err
+		}
+		et, err := m.fsm.AddEventFromAny(name, st)
+		if err != nil {
+			return nil, err
+		}
+		return []*Event{et}, nil
+	})
+}
+
+// AddInternalEvent registers an internal transition of state, see
+// FSM.AddInternalEvent.
+func (m *Machine[S, E, C]) AddInternalEvent(event E, state S) error {
+	return m.addEvent(event, func(name string) ([]*Event, error) {
+		st, err := m.lookup(state)
+		if err != nil {
+			return nil, err
+		}
+		et, err := m.fsm.AddInternalEvent(name, st)
+		if err != nil {
+			return nil, err
+		}
+		return []*Event{et}, nil
+	})
+}
+
+func (m *Machine[S, E, C]) addEvent(event E, add func(name string) ([]*Event, error)) error {
+	name := fmt.Sprint(event)
+	if err := checkName(&m.mutex, name, event, m.eventNames, ErrEventDuplicated); err != nil {
+		return err
+	}
+	ets, err := add(name)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.eventNames[name] = event
+	m.mutex.Unlock()
+	for _, et := range ets {
+		et.AddGuard(func(ctx context.Context, _ *Event) error {
+			return m.dispatch(ctx, event, m.guards)
+		})
+		et.AddHandlerE(func(ctx context.Context, _ *Event) error {
+			return m.dispatch(ctx, event, m.handlers)
+		})
+	}
+	return nil
+}
+
+// dispatch runs the typed handlers or guards of event in order.
+func (m *Machine[S, E, C]) dispatch(ctx context.Context, event E, funcs map[E][]EventFunc[S, E, C]) error {
+	m.mutex.RLock()
+	fs := funcs[event]
+	m.mutex.RUnlock()
+	if len(fs) == 0 {
+		return nil
+	}
+	tr := m.transitionOf(TransitionFromContext(ctx))
+	for _, f := range fs {
+		if err := f(ctx, tr, m.c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Machine[S, E, C]) transitionOf(tr *Transition) TransitionOf[S, E] {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return TransitionOf[S, E]{
+		Event:  m.eventNames[tr.Event],
+		Region: tr.Region,
+		From:   m.stateNames[tr.From],
+		To:     m.stateNames[tr.To],
+		Args:   tr.Args,
+	}
+}
+
+// OnEvent adds a handler to every registration of event, past and future.
+func (m *Machine[S, E, C]) OnEvent(event E, handler EventFunc[S, E, C]) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handlers[event] = append(m.handlers[event], handler)
+}
+
+// Guard adds a guard to every registration of event, past and future. Like
+// GuardHandler it runs while the FSM is locked and must not call back into
+// it.
+func (m *Machine[S, E, C]) Guard(event E, guard EventFunc[S, E, C]) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.guards[event] = append(m.guards[event], guard)
+}
+
+// OnEnter adds an enter hook to state.
+func (m *Machine[S, E, C]) OnEnter(state S, hook StateFunc[S, C]) error {
+	return m.hook(state, hook, (*State).AddEnterE)
+}
+
+// OnLeave adds a leave hook to state.
+func (m *Machine[S, E, C]) OnLeave(state S, hook StateFunc[S, C]) error {
+	return m.hook(state, hook, (*State).AddLeftE)
+}
+
+func (m *Machine[S, E, C]) hook(state S, hook StateFunc[S, C], add func(*State, StateHandlerE)) error {
+	st, err := m.lookup(state)
+	if err != nil {
+		return err
+	}
+	add(st, func(ctx context.Context, _ *State) error {
+		return hook(ctx, state, m.c)
+	})
+	return nil
+}
+
+// MarkFinal flags state as final, see State.MarkFinal.
+func (m *Machine[S, E, C]) MarkFinal(state S) error {
+	st, err := m.lookup(state)
+	if err != nil {
+		return err
+	}
+	st.MarkFinal()
+	return nil
+}
+
+// SetTimeout makes entering state emit event after d, see State.SetTimeout.
+func (m *Machine[S, E, C]) SetTimeout(state S, d time.Duration, event E) error {
+	st, err := m.lookup(state)
+	if err != nil {
+		return err
+	}
+	st.SetTimeout(d, fmt.Sprint(event))
+	return nil
+}
+
+// State returns the current state of the default region.
+func (m *Machine[S, E, C]) State() S {
+	name := m.fsm.State()
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.stateNames[name]
+}
+
+// InStates reports whether any of states is active, see FSM.InStates.
+func (m *Machine[S, E, C]) InStates(states ...S) bool {
+	names := make([]string, 0, len(states))
+	for _, state := range states {
+		names = append(names, fmt.Sprint(state))
+	}
+	return m.fsm.InStates(names...)
+}
+
+func (m *Machine[S, E, C]) Emit(event E, args ...interface{}) error {
+	return m.fsm.EmitEventWithArgs(fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) EmitAsync(event E, args ...interface{}) <-chan error {
+	return m.fsm.EmitEventAsyncWithArgs(fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) EmitPrio(prio int, event E, args ...interface{}) error {
+	return m.fsm.EmitPrioEventWithArgs(prio, fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) EmitPrioAsync(prio int, event E, args ...interface{}) <-chan error {
+	return m.fsm.EmitPrioEventAsyncWithArgs(prio, fmt.Sprint(event), args...)
+}
+
+// EmitContext is Emit bound to ctx, see FSM.EmitEventContext.
+func (m *Machine[S, E, C]) EmitContext(ctx context.Context, event E, args ...interface{}) error {
+	return m.fsm.EmitEventContext(ctx, fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) EmitAsyncContext(ctx context.Context, event E, args ...interface{}) <-chan error {
+	return m.fsm.EmitEventAsyncContext(ctx, fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) EmitPrioContext(ctx context.Context, prio int, event E, args ...interface{}) error {
+	return m.fsm.EmitPrioEventContext(ctx, prio, fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) EmitPrioAsyncContext(ctx context.Context, prio int, event E, args ...interface{}) <-chan error {
+	return m.fsm.EmitPrioEventAsyncContext(ctx, prio, fmt.Sprint(event), args...)
+}
+
+// EmitAfter emits event once d elapsed, see FSM.EmitEventAfter.
+func (m *Machine[S, E, C]) EmitAfter(d time.Duration, event E, args ...interface{}) *Timer {
+	return m.fsm.EmitEventAfter(d, fmt.Sprint(event), args...)
+}
+
+// EmitAt is EmitAfter at t.
+func (m *Machine[S, E, C]) EmitAt(t time.Time, event E, args ...interface{}) *Timer {
+	return m.fsm.EmitEventAt(t, fmt.Sprint(event), args...)
+}
+
+func (m *Machine[S, E, C]) Done() <-chan struct{} {
+	return m.fsm.Done()
+}
+
+func (m *Machine[S, E, C]) Close() {
+	m.fsm.Close()
+}
+
+func (m *Machine[S, E, C]) Shutdown(ctx context.Context) (int, error) {
+	return m.fsm.Shutdown(ctx)
+}
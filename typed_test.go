@@ -0,0 +1,152 @@
+package yafsm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/singchia/yafsm/yafsmtest"
+)
+
+type connState int
+
+const (
+	connClosed connState = iota
+	connSynSent
+	connEstablished
+)
+
+func (s connState) String() string {
+	return [...]string{"closed", "syn_sent", "established"}[s]
+}
+
+type connEvent string
+
+const (
+	evDial    connEvent = "dial"
+	evSynAck  connEvent = "synack"
+	evTimeout connEvent = "timeout"
+	evReset   connEvent = "reset"
+)
+
+type conn struct {
+	log []string
+}
+
+func newConnMachine(t *testing.T, opts ...FSMOption) *Machine[connState, connEvent, *conn] {
+	m := New[connState, connEvent](connClosed, &conn{}, opts...)
+	for _, st := range []connState{connSynSent, connEstablished} {
+		if err := m.AddState(st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, err := range []error{
+		m.AddEvent(evDial, connClosed, connSynSent),
+		m.AddEvent(evSynAck, connSynSent, connEstablished),
+		m.AddEvent(evTimeout, connSynSent, connClosed),
+		m.AddEventFromAny(evReset, connClosed),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestMachine(t *testing.T) {
+	for _, opts := range [][]FSMOption{nil, {WithInSeq()}, {WithAsync()}} {
+		m := newConnMachine(t, opts...)
+		m.OnLeave(connClosed, func(ctx context.Context, st connState, c *conn) error {
+			c.log = append(c.log, "leave "+st.String())
+			return nil
+		})
+		m.OnEnter(connEstablished, func(ctx context.Context, st connState, c *conn) error {
+			c.log = append(c.log, "enter "+st.String())
+			return nil
+		})
+		// added after the event was registered
+		m.OnEvent(evDial, func(ctx context.Context, tr TransitionOf[connState, connEvent], c *conn) error {
+			if tr.Event != evDial || tr.From != connClosed || tr.To != connSynSent || tr.Args[0] != "10.0.0.1" {
+				t.Errorf("unexpected transition %+v", tr)
+			}
+			c.log = append(c.log, "dial")
+			return nil
+		})
+
+		if err := m.Emit(evDial, "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-m.EmitPrioAsync(2, evSynAck); err != nil {
+			t.Fatal(err)
+		}
+		if m.State() != connEstablished || !m.InStates(connSynSent, connEstablished) {
+			t.Fatalf("want established, got %v", m.State())
+		}
+		if want := []string{"leave closed", "dial", "enter established"}; !reflect.DeepEqual(m.Context().log, want) {
+			t.Fatalf("want %v, got %v", want, m.Context().log)
+		}
+		if err := m.Emit(evSynAck); !errors.Is(err, ErrIllegalStateForEvent) {
+			t.Fatalf("want ErrIllegalStateForEvent, got %v", err)
+		}
+		// the underlying FSM goes by name
+		if m.FSM().State() != "established" {
+			t.Fatalf("unexpected name %s", m.FSM().State())
+		}
+		m.Close()
+	}
+}
+
+func TestMachineGuard(t *testing.T) {
+	m := newConnMachine(t)
+	m.Guard(evReset, func(ctx context.Context, tr TransitionOf[connState, connEvent], c *conn) error {
+		if tr.From == connClosed {
+			return errors.New("already closed")
+		}
+		return nil
+	})
+	if err := m.Emit(evReset); !errors.Is(err, ErrGuardRejected) {
+		t.Fatalf("want ErrGuardRejected, got %v", err)
+	}
+	m.Emit(evDial)
+	if err := m.Emit(evReset); err != nil || m.State() != connClosed {
+		t.Fatalf("want closed, got %v %v", m.State(), err)
+	}
+}
+
+func TestMachineTimeout(t *testing.T) {
+	clock := yafsmtest.NewClock(time.Unix(0, 0))
+	m := newConnMachine(t, WithClock(clock))
+	if err := m.SetTimeout(connSynSent, time.Second, evTimeout); err != nil {
+		t.Fatal(err)
+	}
+	m.Emit(evDial)
+	clock.Advance(time.Second)
+	if m.State() != connClosed {
+		t.Fatalf("want closed, got %v", m.State())
+	}
+}
+
+type dupState int
+
+func (dupState) String() string { return "same" }
+
+func TestMachineErrors(t *testing.T) {
+	m := New[connState, connEvent](connClosed, &conn{})
+	if err := m.AddEvent(evDial, connClosed, connSynSent); !errors.Is(err, ErrStateNotExist) {
+		t.Fatalf("want ErrStateNotExist, got %v", err)
+	}
+	if err := m.OnEnter(connSynSent, nil); !errors.Is(err, ErrStateNotExist) {
+		t.Fatalf("want ErrStateNotExist, got %v", err)
+	}
+	m.AddState(connSynSent)
+	if err := m.AddState(connSynSent); err != nil {
+		t.Fatalf("adding a state again is harmless, got %v", err)
+	}
+
+	d := New[dupState, connEvent](dupState(0), struct{}{})
+	if err := d.AddState(dupState(1)); !errors.Is(err, ErrStateDuplicated) {
+		t.Fatalf("want ErrStateDuplicated, got %v", err)
+	}
+}
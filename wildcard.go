@@ -0,0 +1,76 @@
+package yafsm
+
+// AddEventFromAny registers event from every state of to's region, including
+// states added later and to itself. A specific (event, from) registration,
+// own or inherited from a parent state, takes precedence over it, so
+// AddEvent never conflicts with a FromAny event of the same name. Each region
+// has its own FromAny event of a name, an emission moves all of them. Its
+// From is nil.
+func (fsm *FSM) AddEventFromAny(event string, to *State,
+	handlers ...EventHandler) (*Event, error) {
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	if !fsm.stateExists(to.State) {
+		return nil, ErrStateNotExist
+	}
+	if et, ok := fsm.anyEvents[event][to.region]; ok {
+		if et.To.State == to.State {
+			return nil, ErrEventDuplicated
+		}
+		return nil, ErrEventIllegal
+	}
+	hs := make([]EventHandlerE, 0, len(handlers))
+	for _, handler := range handlers {
+		hs = append(hs, wrapEventHandler(handler))
+	}
+	et := &Event{
+		Event:    event,
+		To:       to,
+		handlers: hs,
+	}
+	if fsm.anyEvents[event] == nil {
+		fsm.anyEvents[event] = make(map[string]*Event)
+	}
+	fsm.anyEvents[event][to.region] = et
+	return et, nil
+}
+
+// AddEventFrom registers event from each of froms to to. Either all of them
+// are added or, if any fails the AddEvent checks, none.
+func (fsm *FSM) AddEventFrom(event string, froms []*State, to *State,
+	handlers ...EventHandler) ([]*Event, error) {
+
+	fsm.mutex.Lock()
+	defer fsm.mutex.Unlock()
+
+	seen := make(map[string]struct{}, len(froms))
+	for _, from := range froms {
+		if _, ok := seen[from.State]; ok {
+			return nil, ErrEventDuplicated
+		}
+		seen[from.State] = struct{}{}
+		if err := fsm.checkEvent(event, from, to); err != nil {
+			return nil, err
+		}
+	}
+	ets := make([]*Event, 0, len(froms))
+	for _, from := range froms {
+		ets = append(ets, fsm.addEvent(event, from, to, handlers))
+	}
+	return ets, nil
+}
+
+// FromAny reports whether et was added by AddEventFromAny.
+func (et *Event) FromAny() bool {
+	return et.From == nil
+}
+
+func (fsm *FSM) eventExists(event string) bool {
+	if _, ok := fsm.events[event]; ok {
+		return true
+	}
+	_, ok := fsm.anyEvents[event]
+	return ok
+}
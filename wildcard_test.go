@@ -0,0 +1,164 @@
+package yafsm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAddEventFromAny(t *testing.T) {
+	fsm := newAB()
+	a := fsm.GetState(stateA)
+	et, err := fsm.AddEventFromAny("reset", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !et.FromAny() {
+		t.Fatal("FromAny should be true")
+	}
+	fired := 0
+	et.AddHandler(func(*Event) { fired++ })
+
+	if err := fsm.EmitEvent(evAB); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsm.EmitEvent("reset"); err != nil || fsm.State() != stateA {
+		t.Fatalf("reset from B: err=%v state=%q", err, fsm.State())
+	}
+	// states added afterwards are covered too
+	c := fsm.AddState(stateC)
+	fsm.SetState(stateC)
+	if err := fsm.EmitEvent("reset"); err != nil || fsm.State() != stateA {
+		t.Fatalf("reset from C: err=%v state=%q", err, fsm.State())
+	}
+	if fired != 2 {
+		t.Fatalf("handler fired %d times, want 2", fired)
+	}
+
+	// a specific registration wins
+	if _, err := fsm.AddEvent("reset", c, fsm.GetState(stateB)); err != nil {
+		t.Fatal(err)
+	}
+	fsm.SetState(stateC)
+	if err := fsm.EmitEvent("reset"); err != nil || fsm.State() != stateB {
+		t.Fatalf("specific reset from C: err=%v state=%q", err, fsm.State())
+	}
+	if got := fsm.GetEvents("reset"); len(got) != 2 {
+		t.Fatalf("GetEvents should include the FromAny event, got %d", len(got))
+	}
+	if fsm.GetEvent("reset", nil, a) != et {
+		t.Fatal("GetEvent(nil from) should return the FromAny event")
+	}
+}
+
+func TestAddEventFromAnyErrors(t *testing.T) {
+	fsm := newAB()
+	a := fsm.GetState(stateA)
+	b := fsm.GetState(stateB)
+	if _, err := fsm.AddEventFromAny("reset", NewState("ghost")); !errors.Is(err, ErrStateNotExist) {
+		t.Fatalf("want ErrStateNotExist, got %v", err)
+	}
+	fsm.AddEventFromAny("reset", a)
+	if _, err := fsm.AddEventFromAny("reset", a); !errors.Is(err, ErrEventDuplicated) {
+		t.Fatalf("want ErrEventDuplicated, got %v", err)
+	}
+	if _, err := fsm.AddEventFromAny("reset", b); !errors.Is(err, ErrEventIllegal) {
+		t.Fatalf("want ErrEventIllegal, got %v", err)
+	}
+	// deleting the target drops the FromAny event
+	fsm.DelState(stateA)
+	if got := fsm.GetEvents("reset"); got != nil {
+		t.Fatalf("reset should be gone, got %v", got)
+	}
+}
+
+func TestAddEventFromAnyStaysInRegion(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	fsm.EmitEvent("connect")
+	if _, err := fsm.AddEventFromAny("logout", fsm.GetState("anonymous")); err != nil {
+		t.Fatal(err)
+	}
+	fsm.EmitEvent("login")
+	if err := fsm.EmitEvent("logout"); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.State() != "up" || fsm.RegionState("auth") != "anonymous" {
+		t.Fatalf("only the auth region should move: %v", fsm.ActiveStates())
+	}
+	if !fsm.DelEvent("logout", nil, fsm.GetState("anonymous")) {
+		t.Fatal("DelEvent(nil from) should remove the FromAny event")
+	}
+}
+
+func TestAddEventFromAnyPerRegion(t *testing.T) {
+	fsm := newLinkAuthFSM(t)
+	down, anon := fsm.GetState("down"), fsm.GetState("anonymous")
+	if _, err := fsm.AddEventFromAny("reset", down); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsm.AddEventFromAny("reset", anon); err != nil {
+		t.Fatalf("each region may have its own FromAny event: %v", err)
+	}
+	if _, err := fsm.AddEventFromAny("reset", fsm.GetState("authenticated")); !errors.Is(err, ErrEventIllegal) {
+		t.Fatalf("want ErrEventIllegal, got %v", err)
+	}
+	if got := fsm.GetEvents("reset"); len(got) != 2 || got[0].To != down || got[1].To != anon {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	// one emission moves both regions
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("login")
+	if err := fsm.EmitEvent("reset"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "anonymous"}) {
+		t.Fatalf("ActiveStates = %v", got)
+	}
+
+	if !fsm.DelEvent("reset", nil, anon) || fsm.GetEvent("reset", nil, down) == nil {
+		t.Fatal("DelEvent should only remove the auth FromAny event")
+	}
+	fsm.EmitEvent("connect")
+	fsm.EmitEvent("login")
+	fsm.EmitEvent("reset")
+	if got := fsm.ActiveStates(); !reflect.DeepEqual(got, []string{"down", "authenticated"}) {
+		t.Fatalf("ActiveStates = %v", got)
+	}
+}
+
+func TestAddEventFrom(t *testing.T) {
+	fsm := NewFSM()
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	c := fsm.AddState(stateC)
+	ets, err := fsm.AddEventFrom("close", []*State{a, b}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ets) != 2 {
+		t.Fatalf("want 2 events, got %d", len(ets))
+	}
+	if err := fsm.EmitEvent("close"); err != nil || fsm.State() != stateC {
+		t.Fatalf("err=%v state=%q", err, fsm.State())
+	}
+
+	// all or nothing
+	if _, err := fsm.AddEventFrom("open", []*State{c, b, c}, a); !errors.Is(err, ErrEventDuplicated) {
+		t.Fatalf("want ErrEventDuplicated, got %v", err)
+	}
+	if _, err := fsm.AddEventFrom("close", []*State{c, a}, b); !errors.Is(err, ErrEventIllegal) {
+		t.Fatalf("want ErrEventIllegal, got %v", err)
+	}
+	if got := fsm.GetEvents("open"); got != nil {
+		t.Fatalf("failed AddEventFrom must not register anything: %v", got)
+	}
+	if got := fsm.GetEvents("close"); len(got) != 2 {
+		t.Fatalf("failed AddEventFrom must not register anything: %d", len(got))
+	}
+	// stays in sync with DelState
+	fsm.DelState(stateB)
+	if got := fsm.GetEvents("close"); len(got) != 1 {
+		t.Fatalf("want 1 close event left, got %d", len(got))
+	}
+}
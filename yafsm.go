@@ -3,17 +3,36 @@ package yafsm
 import (
 	"container/list"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/singchia/yafsm/pkg/prioqueue"
 )
 
 type StateHandler func(st *State)
 
+// StateHandlerE is the error-returning variant of StateHandler, a non-nil
+// error aborts the transition and is reported to the emitter.
+type StateHandlerE func(ctx context.Context, st *State) error
+
 type State struct {
 	State  string
-	enters []StateHandler
-	lefts  []StateHandler
+	region string
+	parent *State
+	// terminal states are intended to have no way out, final ones complete
+	// the FSM
+	terminal, final bool
+	enters          []StateHandlerE
+	lefts           []StateHandlerE
+	// names of the hooks added by a Definition
+	enterNames, leftNames []string
+	// set by SetTimeout
+	timeout      time.Duration
+	timeoutEvent string
 }
 
 func NewState(state string) *State {
@@ -21,20 +40,47 @@ func NewState(state string) *State {
 }
 
 func (st *State) AddEnter(handler StateHandler) {
-	st.enters = append(st.enters, handler)
+	st.enters = append(st.enters, wrapStateHandler(handler))
 }
 
 func (st *State) AddLeft(handler StateHandler) {
+	st.lefts = append(st.lefts, wrapStateHandler(handler))
+}
+
+func (st *State) AddEnterE(handler StateHandlerE) {
+	st.enters = append(st.enters, handler)
+}
+
+func (st *State) AddLeftE(handler StateHandlerE) {
 	st.lefts = append(st.lefts, handler)
 }
 
+func wrapStateHandler(handler StateHandler) StateHandlerE {
+	return func(_ context.Context, st *State) error {
+		handler(st)
+		return nil
+	}
+}
+
 type EventHandler func(event *Event)
 
+// EventHandlerE is the error-returning variant of EventHandler.
+type EventHandlerE func(ctx context.Context, event *Event) error
+
+// GuardHandler is evaluated before the state changes, a non-nil error vetoes
+// the transition. Guards run while the FSM is locked and must not call back
+// into it.
+type GuardHandler func(ctx context.Context, event *Event) error
+
 type Event struct {
 	Event    string
 	From, To *State
-	handlers []EventHandler
+	handlers []EventHandlerE
+	guards   []GuardHandler
+	internal bool
 	ch       chan error
+	// names of the handlers added by a Definition
+	handlerNames, guardNames []string
 }
 
 type dup byte
@@ -59,9 +105,37 @@ func (et *Event) duplicate(event, from, to string) dup {
 }
 
 func (et *Event) AddHandler(handler EventHandler) {
+	et.handlers = append(et.handlers, wrapEventHandler(handler))
+}
+
+func (et *Event) AddHandlerE(handler EventHandlerE) {
 	et.handlers = append(et.handlers, handler)
 }
 
+func wrapEventHandler(handler EventHandler) EventHandlerE {
+	return func(_ context.Context, et *Event) error {
+		handler(et)
+		return nil
+	}
+}
+
+func (et *Event) AddGuard(guard GuardHandler) {
+	et.guards = append(et.guards, guard)
+}
+
+// FailurePolicy decides what happens to the state when an error-returning
+// handler fails.
+type FailurePolicy int
+
+const (
+	// FailureKeep keeps the new state and reports the error.
+	FailureKeep FailurePolicy = iota
+	// FailureRollback restores the previous state and runs the compensating
+	// hooks: leave hooks of the new state if it was being entered, then the
+	// enter hooks of the previous state.
+	FailureRollback
+)
+
 type FSMOption func(*FSM)
 
 func WithAsync() FSMOption {
@@ -76,12 +150,59 @@ func WithInSeq() FSMOption {
 	}
 }
 
+func WithFailurePolicy(policy FailurePolicy) FSMOption {
+	return func(fsm *FSM) {
+		fsm.failure = policy
+	}
+}
+
 type FSM struct {
 	state  string
 	states map[string]*State
 	events map[string]*list.List
 
+	// events registered with AddEventFromAny by name and region, the inner
+	// maps are never empty
+	anyEvents map[string]map[string]*Event
+
+	// current states of the named regions
+	regions     map[string]string
+	regionOrder []string
+	// initial states by region, as set by Init and AddRegion
+	initials map[string]string
+
+	completed  bool
+	done       chan struct{}
+	completion func(final *State)
+
+	history *history
+	store   Store
+	// numbers the state changes, see Entry.Seq
+	seq uint64
+	// the highest Seq of the snapshots taken
+	snapped atomic.Uint64
+	// set by WithEventSourcing
+	sourcing   bool
+	replayMode ReplayMode
+	listeners  []Listener
+
+	id        string
+	logger    *slog.Logger
+	logLevels [logCategories]slog.Level
+
+	panicPolicy PanicPolicy
+	panicState  string
+
+	closed  bool
+	pending sync.WaitGroup
+
+	clock  Clock
+	timers map[*Timer]struct{}
+	// the timeouts armed for the states entered
+	timeouts map[*State]*Timer
+
 	async, inseq bool
+	failure      FailurePolicy
 	mutex        sync.RWMutex
 	pq           *prioqueue.PrioQueue
 	cancel       context.CancelFunc
@@ -91,10 +212,19 @@ func NewFSM(opts ...FSMOption) *FSM {
 	pq, _ := prioqueue.NewPrioQueue()
 	ctx, cancel := context.WithCancel(context.Background())
 	fsm := &FSM{
-		pq:     pq,
-		cancel: cancel,
-		states: make(map[string]*State),
-		events: make(map[string]*list.List),
+		pq:        pq,
+		cancel:    cancel,
+		states:    make(map[string]*State),
+		events:    make(map[string]*list.List),
+		regions:   make(map[string]string),
+		anyEvents: make(map[string]map[string]*Event),
+		initials:  make(map[string]string),
+		done:      make(chan struct{}),
+		id:        newFSMID(),
+		logLevels: defaultLogLevels,
+		clock:     realClock{},
+		timers:    make(map[*Timer]struct{}),
+		timeouts:  make(map[*State]*Timer),
 	}
 	for _, opt := range opts {
 		opt(fsm)
@@ -107,10 +237,23 @@ func NewFSM(opts ...FSMOption) *FSM {
 
 func (fsm *FSM) Init(state string) *State {
 	fsm.state = state
+	fsm.initials[""] = state
 	return fsm.AddState(state)
 }
 
+// Close stops accepting events, answers the queued ones with ErrFSMClosed
+// and releases the FSM without waiting for the transition in flight, see
+// Shutdown.
 func (fsm *FSM) Close() {
+	if !fsm.stop() {
+		return
+	}
+	fsm.drop()
+	fsm.release()
+}
+
+// release tears the FSM down once it's stopped.
+func (fsm *FSM) release() {
 	fsm.mutex.Lock()
 	defer fsm.mutex.Unlock()
 
@@ -124,8 +267,15 @@ func (fsm *FSM) Close() {
 		}
 		delete(fsm.events, k)
 	}
+	for k, ets := range fsm.anyEvents {
+		for _, event := range ets {
+			event.handlers, event.To = nil, nil
+		}
+		delete(fsm.anyEvents, k)
+	}
 	fsm.pq.Close()
 	fsm.cancel()
+	fsm.log(context.Background(), LogClose, "fsm closed", slog.String("state", fsm.state))
 }
 
 func (fsm *FSM) emit(ctx context.Context) {
@@ -134,111 +284,285 @@ func (fsm *FSM) emit(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
-			fsm.emitOne()
+			if !fsm.emitOne() {
+				// the queue is closed and empty
+				return
+			}
 		}
 	}
 }
 
-func (fsm *FSM) emitOne() {
+func (fsm *FSM) emitOne() bool {
 	data := fsm.pq.PopSync()
 	if data == nil {
-		return
+		return false
 	}
 	switch ec := data.(type) {
 	case *eventchan:
-		et := (*Event)(nil)
-
-		fsm.mutex.RLock()
-		etList, ok := fsm.events[ec.event]
-		if !ok {
-			ec.ch <- ErrEventNotExist
-			close(ec.ch)
-			fsm.mutex.RUnlock()
-			return
-		}
-		fsm.mutex.RUnlock()
+		fsm.handle(ec, false)
+	}
+	return true
+}
 
+// emitOneSync handles an event on the emitter's goroutine. The queue may
+// have been emptied by Close or Shutdown meanwhile, so it doesn't block.
+func (fsm *FSM) emitOneSync() {
+	if fsm.inseq {
 		fsm.mutex.Lock()
-		for elem := etList.Front(); elem != nil; elem = elem.Next() {
-			tmp := elem.Value.(*Event)
-			if tmp.From.State == fsm.state {
-				et = tmp
+		defer fsm.mutex.Unlock()
+	}
+	data := fsm.pq.TryPop()
+	if data == nil {
+		return
+	}
+	switch ec := data.(type) {
+	case *eventchan:
+		fsm.handle(ec, fsm.inseq)
+	}
+}
+
+// handle runs one emission, if locked is true fsm.mutex is held by the
+// caller for the whole emission, otherwise it's only taken around state
+// changes.
+func (fsm *FSM) handle(ec *eventchan, locked bool) {
+	if !ec.run() {
+		// cancelled while queued, the emitter has been answered
+		return
+	}
+	if err := ec.ctx.Err(); err != nil {
+		ec.reply(err)
+		return
+	}
+	now := fsm.clock.Now()
+	ec.em.Started = now
+	var (
+		trs  []*Transition
+		from string
+		err  error
+	)
+	defer func() {
+		// a panic outside of hooks, e.g. in a guard or a listener, the
+		// states stay as they are
+		if p := recover(); p != nil {
+			pe := newPanicError(p)
+			fsm.logPanic(ec, trs, pe)
+			fsm.record(ec, now, from, trs, pe)
+			if !ec.replied {
+				ec.reply(pe)
 			}
 		}
-		if et == nil {
-			ec.ch <- ErrIllegalStateForEvent
-			close(ec.ch)
-			fsm.mutex.Unlock()
-			return
+	}()
+	ec.before()
+	fsm.critical(locked, func() {
+		from = fsm.state
+		trs, err = fsm.prepare(ec)
+	})
+	if err != nil {
+		fsm.rejected(ec, now, from, err)
+		ec.after(fsm.clock.Now(), err)
+		ec.reply(err)
+		return
+	}
+	ec.em.Transitions = trs
+	if !ec.replaying || ec.hooks {
+		err = fsm.fire(ec, trs)
+	}
+	if pe := (*PanicError)(nil); errors.As(err, &pe) {
+		fsm.logPanic(ec, trs, pe)
+	}
+	action := fsm.failureAction(err)
+	// the transitions whose states are set back
+	undone := []*Transition(nil)
+	if err != nil && action == actionKeep {
+		// the regions after the failing one don't move without their hooks
+		trs, undone = started(trs)
+		fsm.critical(locked, func() { fsm.rollback(undone) })
+		ec.em.Transitions = trs
+	}
+	if action == actionKeep && !ec.replaying {
+		// the transition holds, it's persisted before being answered
+		if perr := fsm.persist(ec, now, destinations(trs), false); perr != nil {
+			err = errors.Join(err, perr)
+			action = fsm.failureAction(err)
 		}
-		fsm.state = et.To.State
-		fsm.mutex.Unlock()
-
-		for _, left := range et.From.lefts {
-			left(et.From)
+	}
+	switch action {
+	case actionRollback:
+		fsm.critical(locked, func() { fsm.rollback(trs) })
+		err = fsm.compensate(trs, err)
+		undone = append(undone, trs...)
+	case actionRevert:
+		fsm.critical(locked, func() { fsm.rollback(trs) })
+		undone = append(undone, trs...)
+	case actionFallback:
+		states := map[string]string(nil)
+		fsm.critical(locked, func() {
+			states = fsm.fallback(trs)
+			fsm.seq++
+			ec.seq = fsm.seq
+		})
+		if !ec.replaying {
+			if perr := fsm.persist(ec, now, states, true); perr != nil {
+				err = errors.Join(err, perr)
+			}
 		}
-		for _, handler := range et.handlers {
-			handler(et)
+	}
+	if len(undone) > 0 && !ec.replaying {
+		if perr := fsm.persistUndone(ec, now, undone, locked); perr != nil {
+			err = errors.Join(err, perr)
 		}
-		for _, enter := range et.To.enters {
-			enter(et.To)
+	}
+	final, region := (*State)(nil), trs[len(trs)-1].Region
+	fsm.critical(locked, func() {
+		if action == actionKeep && (!ec.replaying || ec.hooks) {
+			fsm.rearm(trs)
 		}
-		ec.ch <- nil
-		close(ec.ch)
+		if st, ok := fsm.states[fsm.panicState]; ok && action == actionFallback {
+			region = st.region
+		}
+		final = fsm.complete(region)
+	})
+	if final != nil && fsm.completion != nil && (!ec.replaying || ec.hooks) {
+		fsm.completion(final)
 	}
+	fsm.record(ec, now, from, trs, err)
+	ec.after(fsm.clock.Now(), err)
+	ec.reply(err)
 }
 
-func (fsm *FSM) emitOneInSeq() {
-	fsm.mutex.Lock()
-	defer fsm.mutex.Unlock()
+// critical runs fn holding fsm.mutex, unless the caller already does.
+func (fsm *FSM) critical(locked bool, fn func()) {
+	if !locked {
+		fsm.mutex.Lock()
+		defer fsm.mutex.Unlock()
+	}
+	fn()
+}
 
-	data := fsm.pq.PopSync()
-	if data == nil {
-		return
+// prepare finds, in every region, the event matching the current state,
+// evaluates all their guards and only then commits the new states, so an
+// emission either moves all regions or none. fsm.mutex must be held.
+func (fsm *FSM) prepare(ec *eventchan) ([]*Transition, error) {
+	if fsm.completed {
+		return nil, ErrFSMDone
 	}
-	switch ec := data.(type) {
-	case *eventchan:
+	if ec.timer != nil && !fsm.armed(ec.timer) {
+		// the state was left after the timeout fired
+		return nil, ErrTimerCancelled
+	}
+	etList, ok := fsm.events[ec.event]
+	anyEts, anyOk := fsm.anyEvents[ec.event]
+	if !ok && !anyOk {
+		return nil, ErrEventNotExist
+	}
+	trs := []*Transition{}
+	for _, region := range fsm.regionNames() {
+		cur := fsm.states[fsm.current(region)]
+		if cur == nil {
+			continue
+		}
 		et := (*Event)(nil)
-		etList, ok := fsm.events[ec.event]
-		if !ok {
-			ec.ch <- ErrEventNotExist
-			close(ec.ch)
-			return
+		if ok {
+			et = match(etList, cur)
 		}
-		for elem := etList.Front(); elem != nil; elem = elem.Next() {
-			tmp := elem.Value.(*Event)
-			if tmp.From.State == fsm.state {
-				et = tmp
-			}
+		if et == nil {
+			// specific registrations take precedence over FromAny
+			et = anyEts[region]
 		}
 		if et == nil {
-			ec.ch <- ErrIllegalStateForEvent
-			close(ec.ch)
-			return
+			continue
+		}
+		trs = append(trs, newTransition(ec.ctx, region, cur, et, ec, fsm.clock))
+	}
+	if len(trs) == 0 {
+		return nil, ErrIllegalStateForEvent
+	}
+	for _, tr := range trs {
+		if ec.replaying {
+			// it was let through when first emitted
+			break
+		}
+		for _, guard := range tr.event.guards {
+			if err := guard(tr.ctx, tr.event); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrGuardRejected, tr.Event, err)
+			}
+		}
+	}
+	for _, tr := range trs {
+		fsm.setCurrent(tr.Region, tr.To)
+	}
+	fsm.seq++
+	ec.seq = fsm.seq
+	return trs, nil
+}
+
+// match returns the event in etList defined on cur or, failing that, on the
+// innermost of its ancestors.
+func match(etList *list.List, cur *State) *Event {
+	for st := cur; st != nil; st = st.parent {
+		for elem := etList.Front(); elem != nil; elem = elem.Next() {
+			et := elem.Value.(*Event)
+			if et.From.State == st.State {
+				return et
+			}
 		}
-		fsm.state = et.To.State
-		for _, left := range et.From.lefts {
-			left(et.From)
+	}
+	return nil
+}
+
+// fire runs the transitions region by region and stops at the first failing
+// handler.
+func (fsm *FSM) fire(ec *eventchan, trs []*Transition) error {
+	step := ec.stepFunc()
+	for _, tr := range trs {
+		if err := tr.fire(step); err != nil {
+			return err
 		}
-		for _, handler := range et.handlers {
-			handler(et)
+	}
+	return nil
+}
+
+// started splits trs into the transitions fire got to and the others.
+func started(trs []*Transition) (fired, untouched []*Transition) {
+	for i, tr := range trs {
+		if !tr.started {
+			return trs[:i], trs[i:]
 		}
-		for _, enter := range et.To.enters {
-			enter(et.To)
+	}
+	return trs, nil
+}
+
+// rollback restores the source states unless another emission already moved
+// on, fsm.mutex must be held.
+func (fsm *FSM) rollback(trs []*Transition) {
+	for _, tr := range trs {
+		if fsm.current(tr.Region) == tr.To {
+			fsm.setCurrent(tr.Region, tr.From)
 		}
-		ec.ch <- nil
-		close(ec.ch)
 	}
 }
 
+// compensate undoes the hooks that ran before err in reverse order, errors
+// returned by the compensating hooks are joined to err.
+func (fsm *FSM) compensate(trs []*Transition, err error) error {
+	errs := []error{err}
+	for i := len(trs) - 1; i >= 0; i-- {
+		errs = append(errs, trs[i].compensate()...)
+	}
+	if len(errs) == 1 {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
 func (fsm *FSM) SetState(state string) bool {
 	fsm.mutex.Lock()
 	defer fsm.mutex.Unlock()
-	_, ok := fsm.states[state]
+	st, ok := fsm.states[state]
 	if !ok {
 		return false
 	}
-	fsm.state = state
+	fsm.setCurrent(st.region, state)
 	return true
 }
 
@@ -248,13 +572,47 @@ func (fsm *FSM) State() string {
 	return fsm.state
 }
 
+// QueueStats is a view of the emission queue.
+type QueueStats struct {
+	// Depth is the number of events queued by priority.
+	Depth     map[int]int
+	Available int
+	Capacity  int
+}
+
+func (fsm *FSM) QueueStats() QueueStats {
+	stats := QueueStats{
+		Depth:     make(map[int]int),
+		Available: fsm.pq.Available(),
+		Capacity:  fsm.pq.Len(),
+	}
+	fsm.pq.Range(func(prio int, data interface{}) bool {
+		stats.Depth[prio]++
+		return true
+	})
+	return stats
+}
+
 func (fsm *FSM) InStates(states ...string) bool {
 	fsm.mutex.RLock()
 	defer fsm.mutex.RUnlock()
 
-	for _, state := range states {
-		if state == fsm.state {
-			return true
+	for _, region := range fsm.regionNames() {
+		current := fsm.current(region)
+		for _, state := range states {
+			if state == current {
+				return true
+			}
+		}
+		// ancestors of the current state are active as well
+		if cur, ok := fsm.states[current]; ok {
+			for st := cur.parent; st != nil; st = st.parent {
+				for _, state := range states {
+					if state == st.State {
+						return true
+					}
+				}
+			}
 		}
 	}
 	return false
@@ -287,11 +645,16 @@ func (fsm *FSM) DelState(state string) bool {
 	fsm.mutex.Lock()
 	defer fsm.mutex.Unlock()
 
-	_, ok := fsm.states[state]
+	st, ok := fsm.states[state]
 	if !ok {
 		return false
 	}
 	delete(fsm.states, state)
+	for _, child := range fsm.states {
+		if child.parent == st {
+			child.parent = st.parent
+		}
+	}
 
 	for event, etList := range fsm.events {
 		var next *list.Element
@@ -306,6 +669,16 @@ func (fsm *FSM) DelState(state string) bool {
 			delete(fsm.events, event)
 		}
 	}
+	for event, ets := range fsm.anyEvents {
+		for region, et := range ets {
+			if et.To.State == state {
+				delete(ets, region)
+			}
+		}
+		if len(ets) == 0 {
+			delete(fsm.anyEvents, event)
+		}
+	}
 	return true
 }
 
@@ -320,44 +693,58 @@ func (fsm *FSM) AddEvent(event string, from, to *State,
 	fsm.mutex.Lock()
 	defer fsm.mutex.Unlock()
 
+	if err := fsm.checkEvent(event, from, to); err != nil {
+		return nil, err
+	}
+	return fsm.addEvent(event, from, to, handlers), nil
+}
+
+// checkEvent validates a (event, from, to) registration, fsm.mutex must be
+// held.
+func (fsm *FSM) checkEvent(event string, from, to *State) error {
 	if !fsm.stateExists(from.State) || !fsm.stateExists(to.State) {
-		return nil, ErrStateNotExist
+		return ErrStateNotExist
+	}
+	if fsm.states[from.State].region != fsm.states[to.State].region {
+		return ErrRegionMismatch
 	}
-	et := (*Event)(nil)
 	etList, ok := fsm.events[event]
 	if ok {
 		for elem := etList.Front(); elem != nil; elem = elem.Next() {
-			et = elem.Value.(*Event)
+			et := elem.Value.(*Event)
 			dup := et.duplicate(event, from.State, to.State)
 			switch dup {
 			case dupEventFromTo:
 				// same event, same from, same to
-				return nil, ErrEventDuplicated
+				return ErrEventDuplicated
 			case dupEventFrom:
 				// same event, same from, different to
-				return nil, ErrEventIllegal
+				return ErrEventIllegal
 			}
 		}
-		et = &Event{
-			Event:    event,
-			From:     from,
-			To:       to,
-			handlers: handlers,
-		}
-		etList.PushBack(et)
+	}
+	return nil
+}
 
-	} else {
-		etList := list.New()
-		et = &Event{
-			Event:    event,
-			From:     from,
-			To:       to,
-			handlers: handlers,
-		}
-		etList.PushBack(et)
+// addEvent registers a checked (event, from, to), fsm.mutex must be held.
+func (fsm *FSM) addEvent(event string, from, to *State, handlers []EventHandler) *Event {
+	hs := make([]EventHandlerE, 0, len(handlers))
+	for _, handler := range handlers {
+		hs = append(hs, wrapEventHandler(handler))
+	}
+	et := &Event{
+		Event:    event,
+		From:     from,
+		To:       to,
+		handlers: hs,
+	}
+	etList, ok := fsm.events[event]
+	if !ok {
+		etList = list.New()
 		fsm.events[event] = etList
 	}
-	return et, nil
+	etList.PushBack(et)
+	return et
 }
 
 func (fsm *FSM) GetEvents(event string) []*Event {
@@ -372,15 +759,37 @@ func (fsm *FSM) GetEvents(event string) []*Event {
 			et = elem.Value.(*Event)
 			ets = append(ets, et)
 		}
+	}
+	if anyEts, anyOk := fsm.anyEvents[event]; anyOk {
+		for _, region := range fsm.regionNames() {
+			if et, ok := anyEts[region]; ok {
+				ets = append(ets, et)
+			}
+		}
+		ok = true
+	}
+	if ok {
 		return ets
 	}
 	return nil
 }
 
+// GetEvent returns the (event, from, to) registration, a nil from stands for
+// the one added by AddEventFromAny.
 func (fsm *FSM) GetEvent(event string, from, to *State) *Event {
 	fsm.mutex.RLock()
 	defer fsm.mutex.RUnlock()
 
+	if from == nil {
+		if to == nil {
+			return nil
+		}
+		et, ok := fsm.anyEvents[event][to.region]
+		if ok && et.To == to {
+			return et
+		}
+		return nil
+	}
 	et := (*Event)(nil)
 	etList, ok := fsm.events[event]
 	if ok {
@@ -402,17 +811,35 @@ func (fsm *FSM) DelEvents(event string) bool {
 	defer fsm.mutex.Unlock()
 
 	_, ok := fsm.events[event]
-	if ok {
+	_, anyOk := fsm.anyEvents[event]
+	if ok || anyOk {
 		delete(fsm.events, event)
+		delete(fsm.anyEvents, event)
 		return true
 	}
 	return false
 }
 
+// DelEvent removes the (event, from, to) registration, a nil from stands for
+// the one added by AddEventFromAny.
 func (fsm *FSM) DelEvent(event string, from, to *State) bool {
 	fsm.mutex.Lock()
 	defer fsm.mutex.Unlock()
 
+	if from == nil {
+		if to == nil {
+			return false
+		}
+		et, ok := fsm.anyEvents[event][to.region]
+		if ok && et.To == to {
+			delete(fsm.anyEvents[event], to.region)
+			if len(fsm.anyEvents[event]) == 0 {
+				delete(fsm.anyEvents, event)
+			}
+			return true
+		}
+		return false
+	}
 	et := (*Event)(nil)
 	etList, ok := fsm.events[event]
 	if ok {
@@ -437,124 +864,191 @@ func (fsm *FSM) DelEvent(event string, from, to *State) bool {
 	return false
 }
 
+const (
+	ecQueued int32 = iota
+	ecRunning
+	ecCancelled
+)
+
 type eventchan struct {
+	ctx   context.Context
 	event string
+	args  []interface{}
+	prio  int
 	ch    chan error
+	done  chan struct{}
+	state int32
+
+	// set by Replay
+	replaying, hooks bool
+	replied          bool
+	// the state timeout emitting the event
+	timer *Timer
+	// the state change committed by the emission
+	seq uint64
+
+	em        *Emission
+	listeners []Listener
+	// the FSM's count of emissions not answered yet
+	pending *sync.WaitGroup
 }
 
-func (fsm *FSM) EmitEvent(event string) error {
-	fsm.mutex.RLock()
-	ch := make(chan error, 1)
-	_, ok := fsm.events[event]
-	fsm.mutex.RUnlock()
-	if !ok {
-		return ErrEventNotExist
-	}
+// run marks the eventchan as taken by the dispatcher, false means it was
+// cancelled while still queued.
+func (ec *eventchan) run() bool {
+	return atomic.CompareAndSwapInt32(&ec.state, ecQueued, ecRunning)
+}
 
-	eventchan := &eventchan{
-		event: event,
-		ch:    ch,
+func (ec *eventchan) reply(err error) {
+	ec.replied = true
+	ec.ch <- err
+	close(ec.ch)
+	if ec.done != nil {
+		close(ec.done)
 	}
-	err := fsm.pq.Push(eventchan)
-	if err != nil {
-		return err
+	if ec.pending != nil {
+		ec.pending.Done()
 	}
-	if !fsm.async {
-		if fsm.inseq {
-			fsm.emitOneInSeq()
-		} else {
-			fsm.emitOne()
-		}
-	}
-	err = <-ch
-	return err
 }
 
-func (fsm *FSM) EmitEventAsync(event string) <-chan error {
-	fsm.mutex.RLock()
-	ch := make(chan error, 1)
-	_, ok := fsm.events[event]
-	fsm.mutex.RUnlock()
-	if !ok {
-		ch <- ErrEventNotExist
-		return ch
+// watch answers the emitter with ctx.Err() if ctx is done before the
+// dispatcher takes the eventchan, a running transition is never interrupted.
+func (ec *eventchan) watch() {
+	done := ec.ctx.Done()
+	if done == nil {
+		return
 	}
+	ec.done = make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			if atomic.CompareAndSwapInt32(&ec.state, ecQueued, ecCancelled) {
+				ec.ch <- ec.ctx.Err()
+				close(ec.ch)
+				if ec.pending != nil {
+					ec.pending.Done()
+				}
+			}
+		case <-ec.done:
+		}
+	}()
+}
 
-	eventchan := &eventchan{
-		event: event,
-		ch:    ch,
-	}
+func (fsm *FSM) EmitEvent(event string) error {
+	return <-fsm.push(context.Background(), 1, event, nil)
+}
 
-	err := fsm.pq.Push(eventchan)
-	if err != nil {
-		ch <- err
-		return ch
-	}
-	if !fsm.async {
-		if fsm.inseq {
-			fsm.emitOneInSeq()
-		} else {
-			fsm.emitOne()
-		}
-	}
-	return ch
+func (fsm *FSM) EmitEventAsync(event string) <-chan error {
+	return fsm.push(context.Background(), 1, event, nil)
 }
 
 func (fsm *FSM) EmitPrioEvent(prio int, event string) error {
-	fsm.mutex.RLock()
+	return <-fsm.push(context.Background(), prio, event, nil)
+}
+
+func (fsm *FSM) EmitPrioEventAsync(prio int, event string) <-chan error {
+	return fsm.push(context.Background(), prio, event, nil)
+}
+
+// EmitEventWithArgs emits event and hands args to guards and error-returning
+// handlers through the Transition in their context.
+func (fsm *FSM) EmitEventWithArgs(event string, args ...interface{}) error {
+	return <-fsm.push(context.Background(), 1, event, args)
+}
+
+func (fsm *FSM) EmitEventAsyncWithArgs(event string, args ...interface{}) <-chan error {
+	return fsm.push(context.Background(), 1, event, args)
+}
+
+func (fsm *FSM) EmitPrioEventWithArgs(prio int, event string, args ...interface{}) error {
+	return <-fsm.push(context.Background(), prio, event, args)
+}
+
+func (fsm *FSM) EmitPrioEventAsyncWithArgs(prio int, event string, args ...interface{}) <-chan error {
+	return fsm.push(context.Background(), prio, event, args)
+}
+
+// EmitEventContext is EmitEventWithArgs bound to ctx: if ctx is done while the
+// event is still queued it's skipped and ctx.Err() returned, handlers receive
+// ctx as the parent of their context.
+func (fsm *FSM) EmitEventContext(ctx context.Context, event string, args ...interface{}) error {
+	return <-fsm.push(ctx, 1, event, args)
+}
+
+func (fsm *FSM) EmitEventAsyncContext(ctx context.Context, event string, args ...interface{}) <-chan error {
+	return fsm.push(ctx, 1, event, args)
+}
+
+func (fsm *FSM) EmitPrioEventContext(ctx context.Context, prio int, event string, args ...interface{}) error {
+	return <-fsm.push(ctx, prio, event, args)
+}
+
+func (fsm *FSM) EmitPrioEventAsyncContext(ctx context.Context, prio int, event string, args ...interface{}) <-chan error {
+	return fsm.push(ctx, prio, event, args)
+}
+
+func (fsm *FSM) push(ctx context.Context, prio int, event string, args []interface{}) <-chan error {
 	ch := make(chan error, 1)
-	_, ok := fsm.events[event]
-	fsm.mutex.RUnlock()
-	if !ok {
-		return ErrEventNotExist
+	if err := ctx.Err(); err != nil {
+		ch <- err
+		return ch
 	}
-
-	eventchan := &eventchan{
+	return fsm.enqueue(&eventchan{
+		ctx:   ctx,
 		event: event,
+		args:  args,
+		prio:  prio,
 		ch:    ch,
-	}
-
-	err := fsm.pq.PrioPush(prio, eventchan)
-	if err != nil {
-		return err
-	}
-	if !fsm.async {
-		if fsm.inseq {
-			fsm.emitOneInSeq()
-		} else {
-			fsm.emitOne()
-		}
-	}
-	err = <-ch
-	return err
+		em: &Emission{
+			Event:  event,
+			Prio:   prio,
+			Args:   args,
+			Mode:   fsm.mode(),
+			Queued: fsm.clock.Now(),
+		},
+	})
 }
 
-func (fsm *FSM) EmitPrioEventAsync(prio int, event string) <-chan error {
+// enqueue queues eventchan unless it's rejected right away.
+func (fsm *FSM) enqueue(eventchan *eventchan) <-chan error {
+	ch, event, prio := eventchan.ch, eventchan.event, eventchan.prio
 	fsm.mutex.RLock()
-	ch := make(chan error, 1)
-	_, ok := fsm.events[event]
+	ok, completed, from := fsm.eventExists(event), fsm.completed, fsm.state
+	closed := fsm.closed
+	eventchan.listeners = fsm.listeners
+	if !closed && !completed && ok {
+		// under the lock so Shutdown either waits for it or rejects it
+		eventchan.pending = &fsm.pending
+		fsm.pending.Add(1)
+	}
 	fsm.mutex.RUnlock()
+	if closed {
+		fsm.rejected(eventchan, eventchan.em.Queued, from, ErrFSMClosed)
+		ch <- ErrFSMClosed
+		return ch
+	}
+	if completed {
+		fsm.rejected(eventchan, eventchan.em.Queued, from, ErrFSMDone)
+		ch <- ErrFSMDone
+		return ch
+	}
 	if !ok {
+		fsm.rejected(eventchan, eventchan.em.Queued, from, ErrEventNotExist)
 		ch <- ErrEventNotExist
 		return ch
 	}
 
-	eventchan := &eventchan{
-		event: event,
-		ch:    ch,
-	}
-
+	eventchan.watch()
 	err := fsm.pq.PrioPush(prio, eventchan)
 	if err != nil {
-		ch <- err
+		if eventchan.run() {
+			fsm.rejected(eventchan, fsm.clock.Now(), from, err)
+			eventchan.reply(err)
+		}
 		return ch
 	}
 	if !fsm.async {
-		if fsm.inseq {
-			fsm.emitOneInSeq()
-		} else {
-			fsm.emitOne()
-		}
+		fsm.emitOneSync()
 	}
 	return ch
 }
@@ -1,6 +1,7 @@
 package yafsm
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
@@ -319,3 +320,112 @@ func TestClose(t *testing.T) {
 	fsm := newAB()
 	fsm.Close()
 }
+
+func TestGuardRejects(t *testing.T) {
+	fsm := newAB()
+	ets := fsm.GetEvents(evAB)
+	reason := errors.New("flush in progress")
+	fired := false
+	ets[0].AddGuard(func(ctx context.Context, et *Event) error { return reason })
+	ets[0].AddHandler(func(*Event) { fired = true })
+
+	err := fsm.EmitEvent(evAB)
+	if !errors.Is(err, ErrGuardRejected) || !errors.Is(err, reason) {
+		t.Fatalf("want ErrGuardRejected wrapping reason, got %v", err)
+	}
+	if fsm.State() != stateA {
+		t.Fatalf("state changed on rejected guard: %q", fsm.State())
+	}
+	if fired {
+		t.Fatal("handler ran despite guard rejection")
+	}
+}
+
+func TestGuardAllows(t *testing.T) {
+	fsm := NewFSM(WithInSeq())
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	et, err := fsm.AddEvent(evAB, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	et.AddGuard(func(ctx context.Context, et *Event) error { calls++; return nil })
+	if err := fsm.EmitEvent(evAB); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || fsm.State() != stateB {
+		t.Fatalf("calls=%d state=%q", calls, fsm.State())
+	}
+}
+
+func TestHandlerErrorKeep(t *testing.T) {
+	fsm := newAB()
+	b := fsm.GetState(stateB)
+	boom := errors.New("boom")
+	b.AddEnter(func(*State) {})
+	b.AddEnterE(func(ctx context.Context, st *State) error { return boom })
+
+	err := fsm.EmitEvent(evAB)
+	herr := (*HandlerError)(nil)
+	if !errors.As(err, &herr) || !errors.Is(err, boom) {
+		t.Fatalf("want HandlerError wrapping boom, got %v", err)
+	}
+	if herr.Phase != PhaseEnter || herr.Name != stateB || herr.Index != 1 {
+		t.Fatalf("unexpected handler identity: %+v", herr)
+	}
+	if fsm.State() != stateB {
+		t.Fatalf("keep policy should stay in B, got %q", fsm.State())
+	}
+}
+
+func TestHandlerErrorRollback(t *testing.T) {
+	fsm := NewFSM(WithFailurePolicy(FailureRollback))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	et, err := fsm.AddEvent(evAB, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boom := errors.New("boom")
+	trace := []string{}
+	a.AddLeft(func(*State) { trace = append(trace, "leave A") })
+	a.AddEnter(func(*State) { trace = append(trace, "enter A") })
+	b.AddLeft(func(*State) { trace = append(trace, "leave B") })
+	et.AddHandlerE(func(ctx context.Context, et *Event) error { return boom })
+	b.AddEnter(func(*State) { trace = append(trace, "enter B") })
+
+	ch := fsm.EmitEventAsync(evAB)
+	err = <-ch
+	herr := (*HandlerError)(nil)
+	if !errors.As(err, &herr) || herr.Phase != PhaseEvent || herr.Name != evAB {
+		t.Fatalf("want event HandlerError, got %v", err)
+	}
+	if fsm.State() != stateA {
+		t.Fatalf("rollback policy should restore A, got %q", fsm.State())
+	}
+	want := []string{"leave A", "enter A"}
+	if len(trace) != len(want) || trace[0] != want[0] || trace[1] != want[1] {
+		t.Fatalf("trace = %v, want %v", trace, want)
+	}
+}
+
+func TestHandlerErrorRollbackInEnter(t *testing.T) {
+	fsm := NewFSM(WithInSeq(), WithFailurePolicy(FailureRollback))
+	a := fsm.Init(stateA)
+	b := fsm.AddState(stateB)
+	if _, err := fsm.AddEvent(evAB, a, b); err != nil {
+		t.Fatal(err)
+	}
+	boom := errors.New("boom")
+	left := false
+	b.AddLeft(func(*State) { left = true })
+	b.AddEnterE(func(ctx context.Context, st *State) error { return boom })
+
+	if err := fsm.EmitPrioEvent(3, evAB); !errors.Is(err, boom) {
+		t.Fatalf("want boom, got %v", err)
+	}
+	if fsm.State() != stateA || !left {
+		t.Fatalf("state=%q compensating leave=%v", fsm.State(), left)
+	}
+}
@@ -0,0 +1,111 @@
+// Package yafsmtest provides helpers for testing code built on yafsm.
+package yafsmtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake yafsm.Clock whose time only moves when told to. Timers
+// fire synchronously, on the goroutine advancing the clock, and wait for the
+// emissions they trigger, so once Advance returns those have been handled,
+// by the worker of an asynchronous FSM too. For the same reason Advance must
+// not be called from a hook of a WithAsync FSM, it would wait for the worker
+// running it.
+type Clock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers map[*timer]struct{}
+}
+
+type timer struct {
+	when time.Time
+	// ties are fired in the order they were scheduled
+	seq uint64
+	f   func()
+}
+
+// NewClock returns a Clock set to start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{
+		now:    start,
+		timers: make(map[*timer]struct{}),
+	}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// AfterFunc schedules f to run once the clock was advanced by d, a
+// non-positive d runs it on the next Advance.
+func (c *Clock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.seq++
+	t := &timer{when: c.now.Add(d), seq: c.seq, f: f}
+	c.timers[t] = struct{}{}
+	return func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		_, ok := c.timers[t]
+		delete(c.timers, t)
+		return ok
+	}
+}
+
+// Advance moves the clock forward by d and fires the timers due in order,
+// each at its own time. Timers scheduled meanwhile fire as well if they're
+// due by the end.
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	c.mutex.Unlock()
+	c.Set(end)
+}
+
+// Set moves the clock to t, see Advance. The clock never goes back.
+func (c *Clock) Set(t time.Time) {
+	for {
+		c.mutex.Lock()
+		next := c.next(t)
+		if next == nil {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mutex.Unlock()
+			return
+		}
+		delete(c.timers, next)
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		c.mutex.Unlock()
+		next.f()
+	}
+}
+
+// next returns the earliest timer due by t, c.mutex must be held.
+func (c *Clock) next(t time.Time) *timer {
+	next := (*timer)(nil)
+	for tm := range c.timers {
+		if tm.when.After(t) {
+			continue
+		}
+		if next == nil || tm.when.Before(next.when) ||
+			(tm.when.Equal(next.when) && tm.seq < next.seq) {
+			next = tm
+		}
+	}
+	return next
+}
+
+// Pending returns the number of timers not fired or stopped yet.
+func (c *Clock) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
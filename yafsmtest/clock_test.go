@@ -0,0 +1,52 @@
+package yafsmtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClockAdvance(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewClock(start)
+	fired := []string{}
+	at := map[string]time.Time{}
+	record := func(name string) func() {
+		return func() {
+			fired = append(fired, name)
+			at[name] = c.Now()
+		}
+	}
+	c.AfterFunc(2*time.Second, record("b"))
+	c.AfterFunc(time.Second, record("a"))
+	c.AfterFunc(time.Second, func() {
+		record("a2")()
+		// scheduled while advancing and due by the end
+		c.AfterFunc(time.Second/2, record("c"))
+	})
+	stop := c.AfterFunc(time.Second, record("stopped"))
+	if !stop() || stop() {
+		t.Fatal("only the first stop should stop")
+	}
+
+	c.Advance(time.Second / 2)
+	if len(fired) != 0 || c.Pending() != 3 {
+		t.Fatalf("fired too early %v", fired)
+	}
+	c.Advance(2 * time.Second)
+	if want := []string{"a", "a2", "c", "b"}; !reflect.DeepEqual(fired, want) {
+		t.Fatalf("want %v, got %v", want, fired)
+	}
+	if !at["c"].Equal(start.Add(1500*time.Millisecond)) || !at["b"].Equal(start.Add(2*time.Second)) {
+		t.Fatalf("timers fired at the wrong time %v", at)
+	}
+	if !c.Now().Equal(start.Add(2500*time.Millisecond)) || c.Pending() != 0 {
+		t.Fatalf("unexpected clock %v %d", c.Now(), c.Pending())
+	}
+
+	// the clock doesn't go back
+	c.Set(start)
+	if !c.Now().Equal(start.Add(2500 * time.Millisecond)) {
+		t.Fatal("clock went back")
+	}
+}
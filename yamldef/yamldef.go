@@ -0,0 +1,23 @@
+// Package yamldef loads yafsm definitions written in YAML, the core package
+// only decodes JSON.
+package yamldef
+
+import (
+	"io"
+
+	"github.com/singchia/yafsm"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadDefinition decodes a YAML Definition from reader and builds it, see
+// yafsm.NewFSMFromDefinition. Unknown fields are rejected as with
+// yafsm.LoadDefinition.
+func LoadDefinition(reader io.Reader, registry *yafsm.Registry, opts ...yafsm.FSMOption) (*yafsm.FSM, error) {
+	def := &yafsm.Definition{}
+	dec := yaml.NewDecoder(reader)
+	dec.KnownFields(true)
+	if err := dec.Decode(def); err != nil {
+		return nil, err
+	}
+	return yafsm.NewFSMFromDefinition(def, registry, opts...)
+}
@@ -0,0 +1,73 @@
+package yamldef
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/singchia/yafsm"
+)
+
+const connDefinition = `
+initial: init
+states:
+  - name: init
+  - name: conned
+    enter: [trace]
+  - name: closed
+  - name: anonymous
+    region: auth
+  - name: authenticated
+    region: auth
+regions:
+  - name: auth
+    initial: anonymous
+events:
+  - name: connack
+    from: [init]
+    to: conned
+  - name: login
+    from: [anonymous]
+    to: authenticated
+  - name: ping
+    from: [conned]
+    internal: true
+  - name: fini
+    from: ["*"]
+    to: closed
+`
+
+func TestLoadDefinition(t *testing.T) {
+	trace := []string{}
+	registry := yafsm.NewRegistry()
+	registry.AddStateHandler("trace", func(_ context.Context, st *yafsm.State) error {
+		trace = append(trace, st.State)
+		return nil
+	})
+	fsm, err := LoadDefinition(strings.NewReader(connDefinition), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []string{"connack", "login", "ping", "fini"} {
+		if err := fsm.EmitEvent(ev); err != nil {
+			t.Fatalf("%s: %v", ev, err)
+		}
+	}
+	if got := fsm.ActiveStates(); got[0] != "closed" || got[1] != "authenticated" {
+		t.Fatalf("ActiveStates = %v", got)
+	}
+	if len(trace) != 1 || trace[0] != "conned" {
+		t.Fatalf("trace = %v", trace)
+	}
+}
+
+func TestLoadDefinitionErrors(t *testing.T) {
+	if _, err := LoadDefinition(strings.NewReader("initial: init\nstate: []\n"), nil); err == nil {
+		t.Fatal("unknown fields should be rejected")
+	}
+	def := "initial: init\nstates: [{name: init}]\nevents: [{name: go, from: [init], to: missing}]\n"
+	if _, err := LoadDefinition(strings.NewReader(def), nil); !errors.Is(err, yafsm.ErrStateNotExist) {
+		t.Fatalf("want ErrStateNotExist, got %v", err)
+	}
+}